@@ -0,0 +1,247 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+)
+
+// AggregatedEvent is an event record bundled together with the packet
+// and extra data records that were logged for it.
+//
+// When reading, packet and extra data records without a preceding
+// event record are returned in an AggregatedEvent with a nil Event.
+type AggregatedEvent struct {
+	Event     *EventRecord
+	Packets   []*PacketRecord
+	ExtraData []*ExtraDataRecord
+}
+
+// MarshalJSON encodes the aggregated event as a single JSON object
+// with the event under "event", an array of packets under "packets",
+// both encoded as by their MarshalJSON, and the extra data under
+// "extra_data" as an object keyed by the extra data type.
+//
+// Extra data is decoded with DecodeData, data that can not be decoded
+// is base64 encoded.  If several extra data records have the same
+// type the last one is used.
+func (a *AggregatedEvent) MarshalJSON() ([]byte, error) {
+	extraData := map[string]interface{}{}
+	for _, extra := range a.ExtraData {
+		value, err := extra.DecodeData()
+		if err != nil {
+			value = extra.Data
+		}
+		extraData[strconv.FormatUint(uint64(extra.Type), 10)] = value
+	}
+
+	packets := a.Packets
+	if packets == nil {
+		packets = []*PacketRecord{}
+	}
+
+	return json.Marshal(struct {
+		Event     *EventRecord           `json:"event,omitempty"`
+		Packets   []*PacketRecord        `json:"packets"`
+		ExtraData map[string]interface{} `json:"extra_data"`
+	}{a.Event, packets, extraData})
+}
+
+// MergeExtraData replaces the extra data of the aggregated event with
+// the result of MergeExtraData, joining extra data payloads split over
+// several records.
+func (a *AggregatedEvent) MergeExtraData() {
+	a.ExtraData = MergeExtraData(a.ExtraData)
+}
+
+// aggregator groups records into AggregatedEvents.  Packet and extra
+// data records are added to the preceding event if they belong to it.
+// Records not belonging to the current event are grouped into an
+// aggregate without an event.
+type aggregator struct {
+	current *AggregatedEvent
+	key     EventKey
+}
+
+// add adds a record to the current aggregate.  If the record does not
+// belong to the current aggregate, the current aggregate is complete
+// and returned, otherwise nil is returned.
+func (a *aggregator) add(record interface{}) *AggregatedEvent {
+	var complete *AggregatedEvent
+
+	switch record := record.(type) {
+	case *EventRecord:
+		complete = a.flush()
+		a.current = &AggregatedEvent{Event: record}
+		a.key = record.Key()
+	case *PacketRecord:
+		complete = a.start(EventKey{record.SensorId, record.EventId})
+		a.current.Packets = append(a.current.Packets, record)
+	case *ExtraDataRecord:
+		complete = a.start(EventKey{record.SensorId, record.EventId})
+		a.current.ExtraData = append(a.current.ExtraData, record)
+	default:
+		// Records of unknown types, such as a *RawRecord, can not be
+		// assigned to an event so are skipped.
+	}
+
+	return complete
+}
+
+// start starts a new aggregate without an event, unless the current
+// aggregate is for key, returning the previous aggregate if complete.
+func (a *aggregator) start(key EventKey) *AggregatedEvent {
+	if a.current != nil && a.key == key {
+		return nil
+	}
+	complete := a.flush()
+	a.current = &AggregatedEvent{}
+	a.key = key
+	return complete
+}
+
+// flush returns the current aggregate, if any, and resets the
+// aggregator.
+func (a *aggregator) flush() *AggregatedEvent {
+	complete := a.current
+	a.current = nil
+	return complete
+}
+
+// WalkEvents reads the records of file and calls fn once for each
+// event together with the packet and extra data records that follow
+// it.  Packet and extra data records that do not belong to the
+// preceding event are passed to fn in an AggregatedEvent without an
+// Event.
+//
+// The final event is passed to fn when the end of the file is
+// reached.  If fn returns an error walking stops and the error is
+// returned.  A partial record at the end of the file is returned as
+// ErrBufferTooSmall, after the final event has been passed to fn.
+func WalkEvents(file io.ReadSeeker, fn func(*AggregatedEvent) error) error {
+	var agg aggregator
+
+	for {
+		record, err := ReadRecord(file)
+		if err != nil {
+			e := &ErrBufferTooSmall{}
+			if !errors.As(err, &e) {
+				return err
+			}
+			if complete := agg.flush(); complete != nil {
+				if err := fn(complete); err != nil {
+					return err
+				}
+			}
+			if atEOF(err) {
+				return nil
+			}
+			return err
+		}
+
+		if complete := agg.add(record); complete != nil {
+			if err := fn(complete); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// AggregateReader reads records from a RecordSource and returns them
+// grouped into AggregatedEvents, each being an event record together
+// with the packet and extra data records that follow it and share its
+// SensorId and EventId.
+//
+// AggregateReaders should be created with NewAggregateReader().
+type AggregateReader struct {
+	source RecordSource
+	agg    aggregator
+	closed bool
+}
+
+// NewAggregateReader creates a new AggregateReader reading from
+// source.
+func NewAggregateReader(source RecordSource) *AggregateReader {
+	return &AggregateReader{source: source}
+}
+
+// Next returns the next aggregated event.
+//
+// An event is only complete once the next event, or a record not
+// belonging to it, has been read, or io.EOF is returned by the source,
+// after which the last event is returned followed by io.EOF.  Reaching
+// the end of a file that may still be written, returned as an
+// ErrBufferTooSmall by a RecordReader or as a nil record by a
+// SpoolRecordReader, does not complete the event, as more of its
+// records may follow.  Such results and other errors are returned as
+// is, keeping the incomplete event so Next can be called again once
+// more data is available.
+//
+// Once the reader is closed, Next returns the incomplete event, if
+// any, and then os.ErrClosed.
+//
+// Records of unknown types, returned as a *RawRecord by sources such
+// as a RecordReader with ReturnUnknown set, are skipped, as the event
+// they belong to is not known.
+func (r *AggregateReader) Next() (*AggregatedEvent, error) {
+	if r.closed {
+		if complete := r.agg.flush(); complete != nil {
+			return complete, nil
+		}
+		return nil, os.ErrClosed
+	}
+
+	for {
+		record, err := r.source.Next()
+		if err != nil || record == nil {
+			if err == io.EOF {
+				if complete := r.agg.flush(); complete != nil {
+					return complete, nil
+				}
+			}
+			return nil, err
+		}
+
+		if complete := r.agg.add(record); complete != nil {
+			return complete, nil
+		}
+	}
+}
+
+// Close closes the source, if it is an io.Closer.  The incomplete
+// event, if any, is then returned by Next.
+func (r *AggregateReader) Close() error {
+	r.closed = true
+	if closer, ok := r.source.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package unified2
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestAggregatedEventMarshalJSON(t *testing.T) {
+	raw := readFirstEvent(t)
+	event, err := DecodeEventRecord(raw.Type, raw.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aggregate := &AggregatedEvent{
+		Event: event,
+		Packets: []*PacketRecord{
+			{EventId: event.EventId, Length: 3, Data: []byte{1, 2, 3}},
+		},
+		ExtraData: []*ExtraDataRecord{
+			{EventId: event.EventId, Type: 9, Data: []byte("/index.html")},
+		},
+	}
+
+	buf, err := json.Marshal(aggregate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		Event struct {
+			EventId uint32 `json:"event_id"`
+			SrcIp   string `json:"src_ip"`
+		} `json:"event"`
+		Packets []struct {
+			Packet string `json:"packet"`
+		} `json:"packets"`
+		ExtraData map[string]string `json:"extra_data"`
+	}
+	if err := json.Unmarshal(buf, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Event.EventId != event.EventId {
+		t.Fatalf("unexpected event id in %s", buf)
+	}
+	if decoded.Event.SrcIp != event.IpSource.String() {
+		t.Fatalf("unexpected source address in %s", buf)
+	}
+	if len(decoded.Packets) != 1 || decoded.Packets[0].Packet != "AQID" {
+		t.Fatalf("unexpected packets in %s", buf)
+	}
+	if decoded.ExtraData["9"] != "/index.html" {
+		t.Fatalf("unexpected extra data in %s", buf)
+	}
+}
+
+func TestWalkEvents(t *testing.T) {
+	input, err := os.Open("test/multi-record-event-x2.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	var events []*AggregatedEvent
+	err = WalkEvents(input, func(event *AggregatedEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	for _, event := range events {
+		if len(event.Packets) != 15 || len(event.ExtraData) != 1 {
+			t.Fatalf("expected 15 packets and 1 extra data, got %d and %d",
+				len(event.Packets), len(event.ExtraData))
+		}
+	}
+}
+
+func TestAggregateReader(t *testing.T) {
+	input, err := os.Open("test/multi-record-event-x2.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	// Skip the first event record, so the following records are
+	// without an event.
+	ReadRecord(input)
+
+	reader := NewAggregateReader(&readSeekerSource{input})
+
+	stray, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stray.Event != nil || len(stray.Packets) != 15 || len(stray.ExtraData) != 1 {
+		t.Fatalf("unexpected stray records %+v", stray)
+	}
+
+	// The end of the file does not complete the last event, as more
+	// of its records may still be written, but closing does.
+	if _, err := reader.Next(); !atEOF(err) {
+		t.Fatalf("expected end of file, got %v", err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Event == nil || len(event.Packets) != 15 || len(event.ExtraData) != 1 {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if _, err := reader.Next(); err != os.ErrClosed {
+		t.Fatalf("expected os.ErrClosed, got %v", err)
+	}
+}
+
+// Records appended to a file after the end was reached are added to
+// the event being aggregated.
+func TestAggregateReaderFollow(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := tmpdir + "/unified2.log"
+
+	// All but the last packet of the event, which is 1550 bytes.
+	last := len(buf) - 1550
+	if err := ioutil.WriteFile(filename, buf[:last], 0644); err != nil {
+		t.Fatal(err)
+	}
+	source, err := NewRecordReader(filename, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := NewAggregateReader(source)
+	defer reader.Close()
+
+	if event, err := reader.Next(); !atEOF(err) {
+		t.Fatalf("expected end of file, got %+v, %v", event, err)
+	}
+
+	// Append the last packet and the event of the next file.
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write(buf[last:]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write(buf[:68]); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	event, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Event == nil || len(event.Packets) != 15 || len(event.ExtraData) != 1 {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if _, err := reader.Next(); !atEOF(err) {
+		t.Fatalf("expected end of file, got %v", err)
+	}
+}
+
+// Records of unknown types do not end or join an event.
+func TestAggregateReaderUnknown(t *testing.T) {
+	source := &sliceSource{
+		&EventRecord{SensorId: 1, EventId: 1},
+		&RawRecord{Type: 99, Data: []byte{1, 2, 3, 4}},
+		&PacketRecord{SensorId: 1, EventId: 1},
+	}
+	reader := NewAggregateReader(source)
+
+	event, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Event == nil || len(event.Packets) != 1 {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// Offsets of the addresses in the data of an event record.
+const (
+	eventIpSourceOffset  = 36
+	eventIp4DestOffset   = 40
+	eventIp6DestOffset   = 52
+	eventIp4AddrLen      = 4
+	eventIp6AddrLen      = 16
+	eventIp6MinRecordLen = eventIp6DestOffset + eventIp6AddrLen
+	eventIp4MinRecordLen = eventIp4DestOffset + eventIp4AddrLen
+)
+
+// addrMapper consistently maps addresses to anonymized addresses.
+// IPv4 addresses are mapped into 10.0.0.0/8 and IPv6 addresses into
+// fd00::/8 in the order they are first seen.
+type addrMapper struct {
+	addrs map[string]net.IP
+	next4 uint32
+	next6 uint32
+}
+
+func newAddrMapper() *addrMapper {
+	return &addrMapper{addrs: make(map[string]net.IP)}
+}
+
+// mapAddr returns the anonymized address for addr, which must be either 4
+// or 16 bytes long.
+func (m *addrMapper) mapAddr(addr []byte) net.IP {
+	if mapped, ok := m.addrs[string(addr)]; ok {
+		return mapped
+	}
+
+	var mapped net.IP
+	if len(addr) == eventIp4AddrLen {
+		m.next4++
+		mapped = make(net.IP, eventIp4AddrLen)
+		binary.BigEndian.PutUint32(mapped, 10<<24|m.next4&0xffffff)
+	} else {
+		m.next6++
+		mapped = make(net.IP, eventIp6AddrLen)
+		mapped[0] = 0xfd
+		binary.BigEndian.PutUint32(mapped[12:], m.next6)
+	}
+	m.addrs[string(addr)] = mapped
+	return mapped
+}
+
+// redactEvent replaces the source and destination address in the
+// data of a raw event record with their anonymized addresses.
+func (m *addrMapper) redactEvent(record *RawRecord) {
+	switch record.Type {
+	case UNIFIED2_EVENT_IP6, UNIFIED2_EVENT_V2_IP6, UNIFIED2_EVENT_APPID_IP6:
+		if len(record.Data) < eventIp6MinRecordLen {
+			return
+		}
+		m.redactAddr(record.Data[eventIpSourceOffset:eventIp6DestOffset])
+		m.redactAddr(record.Data[eventIp6DestOffset:eventIp6MinRecordLen])
+	default:
+		if len(record.Data) < eventIp4MinRecordLen {
+			return
+		}
+		m.redactAddr(record.Data[eventIpSourceOffset:eventIp4DestOffset])
+		m.redactAddr(record.Data[eventIp4DestOffset:eventIp4MinRecordLen])
+	}
+}
+
+func (m *addrMapper) redactAddr(addr []byte) {
+	copy(addr, m.mapAddr(addr))
+}
+
+// Anonymize copies the records of in to out with the data that may
+// identify hosts or users removed, for sharing event data for
+// statistics and research.
+//
+// Event records are kept with their source and destination addresses
+// replaced by addresses in 10.0.0.0/8 and fd00::/8.  The mapping is
+// consistent within the file, so the same address is always replaced
+// with the same anonymized address.  Packet records are kept without
+// the packet data and extra data records without their data.  The
+// Length, EventLength and DataLength fields are left unchanged, so
+// they describe the original record.
+//
+// Anonymize reads until the end of in.  A partial record at the end
+// of in is not copied and ErrBufferTooSmall is returned.
+func Anonymize(in io.ReadSeeker, out io.Writer) error {
+	mapper := newAddrMapper()
+
+	for {
+		record, err := ReadRawRecord(in)
+		if err != nil {
+			if atEOF(err) {
+				return nil
+			}
+			return err
+		}
+
+		switch record.Type {
+		case UNIFIED2_PACKET:
+			if len(record.Data) > PACKET_RECORD_HDR_LEN {
+				record.Data = record.Data[:PACKET_RECORD_HDR_LEN]
+			}
+		case UNIFIED2_EXTRA_DATA:
+			if len(record.Data) > EXTRA_DATA_RECORD_HDR_LEN {
+				record.Data = record.Data[:EXTRA_DATA_RECORD_HDR_LEN]
+			}
+		default:
+			mapper.redactEvent(record)
+		}
+
+		if err := writeRawRecord(out, record); err != nil {
+			return err
+		}
+	}
+}
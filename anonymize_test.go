@@ -0,0 +1,63 @@
+package unified2
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestAnonymize(t *testing.T) {
+	input, err := os.Open("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	var out bytes.Buffer
+	if err := Anonymize(input, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bytes.NewReader(out.Bytes())
+	count := 0
+	for {
+		record, err := ReadRecord(reader)
+		if err != nil {
+			if atEOF(err) {
+				break
+			}
+			t.Fatal(err)
+		}
+		count++
+
+		switch record := record.(type) {
+		case *EventRecord:
+			if !record.IpSource.Equal(net.ParseIP("10.0.0.1")) {
+				t.Fatalf("unexpected source %s", record.IpSource)
+			}
+			if !record.IpDestination.Equal(net.ParseIP("10.0.0.2")) {
+				t.Fatalf("unexpected destination %s", record.IpDestination)
+			}
+			if record.SignatureId != 3 || record.EventId != 89 {
+				t.Fatalf("unexpected event %+v", record)
+			}
+		case *PacketRecord:
+			if len(record.Data) != 0 || record.Length == 0 {
+				t.Fatalf("unexpected packet %+v", record)
+			}
+		case *ExtraDataRecord:
+			if len(record.Data) != 0 || record.DataLength != 18578 {
+				t.Fatalf("unexpected extra data %+v", record)
+			}
+		}
+	}
+
+	if count != 17 {
+		t.Fatalf("expected 17 records, got %d", count)
+	}
+	if out.Len() >= 38950/10 {
+		t.Fatalf("expected anonymized output to be smaller, got %d bytes",
+			out.Len())
+	}
+}
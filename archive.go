@@ -0,0 +1,154 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// ArchivePolicy is what a SpoolRecordReader does with a spool file once
+// it has been read and the reader has moved on to the next file.
+type ArchivePolicy int
+
+const (
+	// ArchiveNone leaves the file in place.
+	ArchiveNone ArchivePolicy = iota
+
+	// ArchiveDelete deletes the file.
+	ArchiveDelete
+
+	// ArchiveMove moves the file to the archive directory.
+	ArchiveMove
+
+	// ArchiveCompress gzip compresses the file into the archive
+	// directory, adding a .gz suffix, and deletes the original.
+	ArchiveCompress
+)
+
+func (p ArchivePolicy) String() string {
+	switch p {
+	case ArchiveNone:
+		return "none"
+	case ArchiveDelete:
+		return "delete"
+	case ArchiveMove:
+		return "move"
+	case ArchiveCompress:
+		return "compress"
+	}
+	return fmt.Sprintf("ArchivePolicy(%d)", int(p))
+}
+
+// archiveFile applies policy to filename, moving or compressing it into
+// directory.
+func archiveFile(policy ArchivePolicy, filename string, directory string) error {
+	switch policy {
+	case ArchiveNone:
+		return nil
+	case ArchiveDelete:
+		return os.Remove(filename)
+	case ArchiveMove:
+		return moveFile(filename, path.Join(directory, path.Base(filename)))
+	case ArchiveCompress:
+		dest := path.Join(directory, path.Base(filename)+".gz")
+		if err := compressFile(filename, dest); err != nil {
+			return err
+		}
+		return os.Remove(filename)
+	}
+	return fmt.Errorf("unknown archive policy %d", int(policy))
+}
+
+// moveFile renames source to dest, copying it if it can not be renamed,
+// such as when dest is on another filesystem.
+func moveFile(source string, dest string) error {
+	if err := os.Rename(source, dest); err == nil {
+		return nil
+	}
+
+	err := writeFileAtomic(dest, func(w io.Writer) error {
+		return copyFileTo(source, w)
+	})
+	if err != nil {
+		return err
+	}
+	return os.Remove(source)
+}
+
+// compressFile writes source gzip compressed to dest.
+func compressFile(source string, dest string) error {
+	return writeFileAtomic(dest, func(w io.Writer) error {
+		gz := gzip.NewWriter(w)
+		if err := copyFileTo(source, gz); err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+}
+
+// copyFileTo copies the contents of filename to w.
+func copyFileTo(filename string, w io.Writer) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// writeFileAtomic writes filename with the output of write, through a
+// temporary file synced and renamed into place so a partial file is
+// never left under filename.  The temporary file is hidden, named with
+// a leading dot, so it is not taken for a spool file.
+func writeFileAtomic(filename string, write func(io.Writer) error) error {
+	tmp := path.Join(path.Dir(filename), "."+path.Base(filename)+".tmp")
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = write(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+)
+
+// Bookmark is a position in a unified2 spool, the filename of a spool
+// file and the offset in it of the next record to read, often known as
+// a waldo file.
+//
+// A Bookmark saved as records are processed allows a consumer that is
+// restarted to resume where it left off, for example:
+//
+//	bookmark, err := LoadBookmark(filename)
+//	if err == nil {
+//		err = reader.SeekTo(bookmark.Filename, bookmark.Offset)
+//	}
+type Bookmark struct {
+	Filename string `json:"filename"`
+	Offset   int64  `json:"offset"`
+}
+
+// LoadBookmark reads a Bookmark saved by Save from filename.
+func LoadBookmark(filename string) (*Bookmark, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	bookmark := &Bookmark{}
+	if err := json.Unmarshal(buf, bookmark); err != nil {
+		return nil, err
+	}
+	return bookmark, nil
+}
+
+// Save writes the bookmark to filename as JSON.  The file is replaced
+// atomically, so a crash while saving leaves the previous bookmark
+// intact.
+func (b *Bookmark) Save(filename string) error {
+	buf, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filename, func(w io.Writer) error {
+		_, err := w.Write(buf)
+		return err
+	})
+}
@@ -0,0 +1,171 @@
+package unified2
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestBookmark(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	waldo := tmpdir + "/waldo"
+
+	if _, err := LoadBookmark(waldo); !os.IsNotExist(err) {
+		t.Fatalf("expected a not exist error, got %v", err)
+	}
+
+	copyFile("test/multi-record-event.log",
+		fmt.Sprintf("%s/merged.log.1382627900", tmpdir))
+
+	reader := NewSpoolRecordReader(tmpdir, "merged.log")
+	for i := 0; i < 3; i++ {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := reader.Bookmark().Save(waldo); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bookmark, err := LoadBookmark(waldo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *bookmark != (Bookmark{"merged.log.1382627900", 18941}) {
+		t.Fatalf("unexpected bookmark %+v", bookmark)
+	}
+
+	resumed := NewSpoolRecordReader(tmpdir, "merged.log")
+	if err := resumed.SeekTo(bookmark.Filename, bookmark.Offset); err != nil {
+		t.Fatal(err)
+	}
+	record, err := resumed.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(record, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, record)
+	}
+}
+
+func TestRecordSpoolReaderCommit(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	waldo := tmpdir + "/waldo"
+
+	copyFile("test/multi-record-event.log",
+		fmt.Sprintf("%s/merged.log.1382627900", tmpdir))
+	copyFile("test/multi-record-event.log",
+		fmt.Sprintf("%s/merged.log.1382627901", tmpdir))
+
+	reader := NewSpoolRecordReader(tmpdir, "merged.log")
+	if err := reader.Commit(); err == nil {
+		t.Fatal("expected an error committing without a bookmark file")
+	}
+	if err := reader.Resume(waldo); err != nil {
+		t.Fatal(err)
+	}
+	reader.ArchivePolicy = ArchiveDelete
+
+	for i := 0; i < 16; i++ {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := reader.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Records read after the commit are read again on resuming, and
+	// the first file is only deleted once committed past.
+	var expected []interface{}
+	for i := 0; i < 3; i++ {
+		record, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, record)
+	}
+	first := tmpdir + "/merged.log.1382627900"
+	if _, err := os.Stat(first); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed := NewSpoolRecordReader(tmpdir, "merged.log")
+	if err := resumed.Resume(waldo); err != nil {
+		t.Fatal(err)
+	}
+	resumed.ArchivePolicy = ArchiveDelete
+	for i := 0; i < 3; i++ {
+		record, err := resumed.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(record, expected[i]) {
+			t.Fatalf("record %d: expected %+v, got %+v", i, expected[i], record)
+		}
+	}
+	if err := resumed.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be deleted, got %v", first, err)
+	}
+}
+
+// Committing before a file is opened saves no bookmark, and a bookmark
+// without a filename resumes from the start of the spool.
+func TestRecordSpoolReaderCommitNoFile(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	waldo := tmpdir + "/waldo"
+
+	copyFile("test/multi-record-event.log",
+		fmt.Sprintf("%s/merged.log.1382627900", tmpdir))
+
+	reader := NewSpoolRecordReader(tmpdir, "merged.log")
+	if err := reader.Resume(waldo); err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(waldo); !os.IsNotExist(err) {
+		t.Fatalf("expected no bookmark to be saved, got %v", err)
+	}
+
+	if err := (&Bookmark{}).Save(waldo); err != nil {
+		t.Fatal(err)
+	}
+	reader = NewSpoolRecordReader(tmpdir, "merged.log")
+	if err := reader.Resume(waldo); err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	record, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := record.(*EventRecord); !ok {
+		t.Fatalf("expected the first event, got %+v", record)
+	}
+	if filename, offset := reader.Offset(); filename != "merged.log.1382627900" || offset != 68 {
+		t.Fatalf("unexpected offset %s:%d", filename, offset)
+	}
+}
@@ -0,0 +1,111 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"io"
+)
+
+// The size of the buffer of a bufferedFile.
+const bufferedFileLen = 64 * 1024
+
+// bufferedFile is an io.ReadSeeker reading a file through a buffer, so
+// reading records one after the other does not make a seek and several
+// small reads per record.
+//
+// Seeking within the buffered data, such as back to the start of a
+// partial record, does not touch the file.  The file must not be read
+// or seeked other than through the bufferedFile.
+type bufferedFile struct {
+	file io.ReadSeeker
+
+	// Data read from the file at offset, the file being positioned
+	// at the end of it.
+	buf    []byte
+	offset int64
+
+	// The read position in buf.
+	pos int
+}
+
+func (f *bufferedFile) Read(p []byte) (int, error) {
+	if f.pos == len(f.buf) {
+		f.offset += int64(len(f.buf))
+		f.buf = f.buf[:0]
+		f.pos = 0
+
+		// Large reads are not worth copying through the buffer.
+		if len(p) >= bufferedFileLen {
+			n, err := f.file.Read(p)
+			f.offset += int64(n)
+			return n, err
+		}
+
+		if cap(f.buf) == 0 {
+			f.buf = make([]byte, 0, bufferedFileLen)
+		}
+		n, err := f.file.Read(f.buf[:cap(f.buf)])
+		f.buf = f.buf[:n]
+		if n == 0 {
+			return 0, err
+		}
+	}
+
+	n := copy(p, f.buf[f.pos:])
+	f.pos += n
+	return n, nil
+}
+
+func (f *bufferedFile) Seek(offset int64, whence int) (int64, error) {
+	var target int64
+	switch whence {
+	case 0:
+		target = offset
+	case 1:
+		target = f.offset + int64(f.pos) + offset
+	default:
+		return f.reset(f.file.Seek(offset, whence))
+	}
+
+	if target >= f.offset && target <= f.offset+int64(len(f.buf)) {
+		f.pos = int(target - f.offset)
+		return target, nil
+	}
+	return f.reset(f.file.Seek(target, 0))
+}
+
+// reset discards the buffered data after the file has been seeked to
+// offset.  On error the file position is unchanged, as is the buffer.
+func (f *bufferedFile) reset(offset int64, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	f.offset = offset
+	f.buf = f.buf[:0]
+	f.pos = 0
+	return offset, nil
+}
@@ -0,0 +1,50 @@
+package unified2
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+// Reads and seeks through a bufferedFile must give the same results as
+// on the file itself.
+func TestBufferedFile(t *testing.T) {
+	data, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = bytes.Repeat(data, 4)
+
+	expected := bytes.NewReader(data)
+	file := &bufferedFile{file: bytes.NewReader(data)}
+	random := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 10000; i++ {
+		switch random.Intn(4) {
+		case 0:
+			offset := random.Int63n(int64(len(data)) + 10)
+			whence := random.Intn(2)
+			if whence == 1 {
+				offset -= int64(len(data)) / 2
+			}
+			want, wantErr := expected.Seek(offset, whence)
+			got, err := file.Seek(offset, whence)
+			if (err != nil) != (wantErr != nil) || (err == nil && got != want) {
+				t.Fatalf("seek %d, %d: expected %d, %v, got %d, %v", offset,
+					whence, want, wantErr, got, err)
+			}
+		default:
+			n := random.Intn(2 * bufferedFileLen)
+			want := make([]byte, n)
+			got := make([]byte, n)
+			wantN, wantErr := io.ReadFull(expected, want)
+			gotN, err := io.ReadFull(file, got)
+			if gotN != wantN || err != wantErr || !bytes.Equal(got, want) {
+				t.Fatalf("read %d: expected %d, %v, got %d, %v", n, wantN,
+					wantErr, gotN, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"net"
+)
+
+// ConnReader reads unified2 records from a network connection, such as
+// a TCP or unix domain socket a sensor side forwarder writes records
+// to.  Records split over several reads are buffered until complete.
+// A ConnReader is a Reader, use Source for a RecordSource reading the
+// connection.
+//
+// ConnReaders should be created with DialReader() or NewConnReader().
+type ConnReader struct {
+	*Reader
+
+	conn net.Conn
+}
+
+// DialReader connects to address on the named network, as by net.Dial,
+// and returns a ConnReader reading records from the connection.
+func DialReader(network string, address string) (*ConnReader, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewConnReader(conn), nil
+}
+
+// NewConnReader returns a ConnReader reading records from conn, such as
+// a connection accepted from a net.Listener.
+func NewConnReader(conn net.Conn) *ConnReader {
+	return &ConnReader{Reader: NewReader(conn), conn: conn}
+}
+
+// Conn returns the connection being read.
+func (r *ConnReader) Conn() net.Conn {
+	return r.conn
+}
+
+// Close closes the connection.
+func (r *ConnReader) Close() error {
+	return r.conn.Close()
+}
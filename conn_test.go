@@ -0,0 +1,77 @@
+package unified2
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+// serve accepts a single connection on listener and writes data to it
+// in small chunks, splitting records over several writes.
+func serve(listener net.Listener, data []byte) {
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for len(data) > 0 {
+			n := 1000
+			if n > len(data) {
+				n = len(data)
+			}
+			if _, err := conn.Write(data[:n]); err != nil {
+				return
+			}
+			data = data[n:]
+		}
+	}()
+}
+
+func TestDialReader(t *testing.T) {
+	data, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, network := range []string{"tcp", "unix"} {
+		address := "127.0.0.1:0"
+		if network == "unix" {
+			address = tmpdir + "/unified2.sock"
+		}
+		listener, err := net.Listen(network, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		serve(listener, data)
+
+		reader, err := DialReader(network, listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+
+		count := 0
+		for {
+			_, err := reader.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: %v", network, err)
+			}
+			count++
+		}
+		if count != 17 {
+			t.Fatalf("%s: expected 17 records, got %d", network, count)
+		}
+	}
+}
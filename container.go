@@ -0,0 +1,96 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"net"
+	"reflect"
+	"time"
+)
+
+// RecordContainer holds a decoded record along with its record type.
+//
+// Record is one of *EventRecord, *PacketRecord or *ExtraDataRecord.
+type RecordContainer struct {
+	Type   uint32
+	Record interface{}
+
+	// SourceName is the name of the file the record was read from, if
+	// known.
+	SourceName string
+
+	// Offset is the offset in the input of the start of the record
+	// header, or -1 if not known, and Len the length of the record in
+	// the input, including the header.  The record may be read again
+	// later by seeking to Offset.
+	Offset int64
+	Len    int64
+}
+
+// ToMap returns the fields of the record as a map keyed by the field
+// names of the record struct, for consumers that don't want to deal
+// with the concrete record types.
+//
+// IP addresses are converted to strings, all other fields keep their
+// type.  In addition to the record fields the map contains the record
+// type as "RecordType" and the time of the record as a time.Time
+// under "Timestamp".
+func (c *RecordContainer) ToMap() map[string]interface{} {
+	m := map[string]interface{}{
+		"RecordType": c.Type,
+	}
+
+	value := reflect.ValueOf(c.Record)
+	if value.Kind() != reflect.Ptr || value.IsNil() ||
+		value.Elem().Kind() != reflect.Struct {
+		return m
+	}
+	value = value.Elem()
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		switch fieldValue := value.Field(i).Interface().(type) {
+		case net.IP:
+			if fieldValue == nil {
+				m[field.Name] = ""
+			} else {
+				m[field.Name] = fieldValue.String()
+			}
+		default:
+			m[field.Name] = fieldValue
+		}
+	}
+
+	if record, ok := c.Record.(interface{ Timestamp() time.Time }); ok {
+		m["Timestamp"] = record.Timestamp()
+	}
+
+	return m
+}
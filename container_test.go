@@ -0,0 +1,106 @@
+package unified2
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecordContainerToMap(t *testing.T) {
+	raw := readFirstEvent(t)
+	event, err := DecodeEventRecord(raw.Type, raw.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := (&RecordContainer{Type: raw.Type, Record: event}).ToMap()
+	if m["RecordType"] != uint32(UNIFIED2_EVENT_V2) {
+		t.Fatalf("unexpected RecordType: %v", m["RecordType"])
+	}
+	if m["IpSource"] != event.IpSource.String() {
+		t.Fatalf("unexpected IpSource: %v", m["IpSource"])
+	}
+	if m["SignatureId"] != event.SignatureId {
+		t.Fatalf("unexpected SignatureId: %v", m["SignatureId"])
+	}
+	if !m["Timestamp"].(time.Time).Equal(event.Timestamp()) {
+		t.Fatalf("unexpected Timestamp: %v", m["Timestamp"])
+	}
+	if _, ok := m["hasInnerVlan"]; ok {
+		t.Fatal("unexported fields should not be in the map")
+	}
+
+	packet := &PacketRecord{PacketSecond: 1, Data: []byte{1}}
+	m = (&RecordContainer{Type: UNIFIED2_PACKET, Record: packet}).ToMap()
+	if len(m["Data"].([]byte)) != 1 {
+		t.Fatalf("unexpected Data: %v", m["Data"])
+	}
+	if m["Timestamp"].(time.Time).Unix() != 1 {
+		t.Fatalf("unexpected Timestamp: %v", m["Timestamp"])
+	}
+}
+
+// The containers of all readers carry the offset and length of their
+// record, from which the record can be read again.
+func TestRecordContainerOffset(t *testing.T) {
+	data, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	containers, err := ReadRecords(bytes.NewReader(data), 17)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct{ offset, len int64 }{{0, 68}, {68, 18610}, {18678, 263}}
+	for i, e := range expected {
+		if containers[i].Offset != e.offset || containers[i].Len != e.len {
+			t.Fatalf("record %d: expected offset %d, length %d, got %d, %d",
+				i, e.offset, e.len, containers[i].Offset, containers[i].Len)
+		}
+	}
+	end := containers[16].Offset + containers[16].Len
+	if end != int64(len(data)) {
+		t.Fatalf("expected the last record to end at %d, got %d", len(data), end)
+	}
+
+	reader := NewReader(bytes.NewReader(data))
+	recordReader, err := NewRecordReader("test/multi-record-event.log", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recordReader.Close()
+	for i := 0; i < 17; i++ {
+		a, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := recordReader.NextContainer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Offset != containers[i].Offset || a.Len != containers[i].Len ||
+			b.Offset != containers[i].Offset || b.Len != containers[i].Len {
+			t.Fatalf("record %d: unexpected offsets %d/%d, %d/%d", i,
+				a.Offset, a.Len, b.Offset, b.Len)
+		}
+	}
+
+	// A record is read again from its offset.
+	file := bytes.NewReader(data)
+	file.Seek(containers[5].Offset, 0)
+	raw, err := ReadRawRecord(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container, err := DecodeRawRecord(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(container.Record, containers[5].Record) ||
+		container.Offset != -1 || container.Len != containers[5].Len {
+		t.Fatalf("unexpected container %+v", container)
+	}
+}
@@ -30,8 +30,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
-	"io"
-	"log"
+	"fmt"
+	"net"
 )
 
 // DecodingError is the error returned if an error is encountered
@@ -41,113 +41,139 @@ import (
 // errors.
 var DecodingError = errors.New("DecodingError")
 
-// Helper function for reading binary data as all reads are big
-// endian.
-func read(reader io.Reader, data interface{}) error {
-	return binary.Read(reader, binary.BigEndian, data)
-}
-
-// DecodeEventRecord decodes a raw record into an EventRecord.
+// DecodeError describes a record that could not be decoded.
 //
-// This function will decode any of the event record types.
-func DecodeEventRecord(eventType uint32, data []byte) (*EventRecord, error) {
-
-	event := &EventRecord{}
-
-	reader := bytes.NewBuffer(data)
+// errors.Is reports a DecodeError as both DecodingError and
+// ErrMalformedRecord, so callers checking for those are unaffected.
+type DecodeError struct {
+	// Type is the type of the record, or 0 if not known.
+	Type uint32
+
+	// Offset is the offset of the record in the input, or -1 if not
+	// known.
+	Offset int64
+
+	// Reason is a short description of the problem.
+	Reason string
+}
 
-	// SensorId
-	if err := read(reader, &event.SensorId); err != nil {
-		return nil, err
-	}
-	if err := read(reader, &event.EventId); err != nil {
-		return nil, err
-	}
-	if err := read(reader, &event.EventSecond); err != nil {
-		return nil, err
-	}
-	if err := read(reader, &event.EventMicrosecond); err != nil {
-		return nil, err
-	}
+func newDecodeError(recordType uint32, reason string) *DecodeError {
+	return &DecodeError{Type: recordType, Offset: -1, Reason: reason}
+}
 
-	/* SignatureId */
-	if err := read(reader, &event.SignatureId); err != nil {
-		return nil, err
+func (e *DecodeError) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("%v: record type %d: %s", DecodingError,
+			e.Type, e.Reason)
 	}
+	return fmt.Sprintf("%v: record type %d at offset %d: %s",
+		DecodingError, e.Type, e.Offset, e.Reason)
+}
 
-	/* GeneratorId */
-	if err := read(reader, &event.GeneratorId); err != nil {
-		return nil, err
-	}
+// Is returns true if target is DecodingError or ErrMalformedRecord.
+func (e *DecodeError) Is(target error) bool {
+	return target == DecodingError || target == ErrMalformedRecord
+}
 
-	/* SignatureRevision */
-	if err := read(reader, &event.SignatureRevision); err != nil {
-		return nil, err
+// withOffset sets the Offset of a DecodeError in err to offset, if not
+// already known.
+func withOffset(err error, offset int64) error {
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) && decodeErr.Offset < 0 {
+		decodeErr.Offset = offset
 	}
+	return err
+}
 
-	/* ClassificationId */
-	if err := read(reader, &event.ClassificationId); err != nil {
-		return nil, err
-	}
+// DecodeInnerVlan enables decoding the inner VLAN fields some
+// producers append to V2 events, see EventRecord.  Those 4 bytes can
+// not be told apart from other data appended by a producer, so this is
+// off by default, leaving them in the Trailer.  When enabled they are
+// only decoded from records of exactly the length of that layout.
+var DecodeInnerVlan = false
 
-	/* Priority */
-	if err := read(reader, &event.Priority); err != nil {
+// DecodeEventRecord decodes a raw record into an EventRecord.
+//
+// This function will decode any of the event record types.  The
+// Trailer of the returned event refers to the memory of data, it is
+// not copied.  A DecodeError is returned if data is too short for the
+// layout of the event type.  As an exception, V2 events ending right
+// before the MPLS and VLAN fields, as written by some older Snort
+// builds, are decoded with those fields left zero.
+func DecodeEventRecord(eventType uint32, data []byte) (*EventRecord, error) {
+	event := &EventRecord{}
+	if err := DecodeEventRecordInto(eventType, data, event); err != nil {
 		return nil, err
 	}
+	return event, nil
+}
 
-	/* Source and destination IP addresses. */
+// DecodeEventRecordInto decodes a raw event record into dst, as
+// DecodeEventRecord, to avoid allocating a new EventRecord for every
+// record.
+//
+// All fields of dst are overwritten.  The IpSource and IpDestination
+// slices of dst are reused if large enough, so addresses of the
+// previous event held elsewhere are overwritten too, use Copy to keep
+// an event.  The contents of dst are undefined if an error is
+// returned.
+func DecodeEventRecordInto(eventType uint32, data []byte, dst *EventRecord) error {
+	var addrLen int
 	switch eventType {
-
 	case UNIFIED2_EVENT, UNIFIED2_EVENT_V2, UNIFIED2_EVENT_APPID:
-		event.IpSource = make([]byte, 4)
-		if err := read(reader, &event.IpSource); err != nil {
-			log.Fatal(err)
-			return nil, err
-		}
-		event.IpDestination = make([]byte, 4)
-		if err := read(reader, &event.IpDestination); err != nil {
-			return nil, err
-		}
-
+		addrLen = net.IPv4len
 	case UNIFIED2_EVENT_IP6, UNIFIED2_EVENT_V2_IP6, UNIFIED2_EVENT_APPID_IP6:
-		event.IpSource = make([]byte, 16)
-		if err := read(reader, &event.IpSource); err != nil {
-			return nil, err
-		}
-		event.IpDestination = make([]byte, 16)
-		if err := read(reader, &event.IpDestination); err != nil {
-			return nil, err
-		}
-	}
-
-	/* Source port/ICMP type. */
-	if err := read(reader, &event.SportItype); err != nil {
-		return nil, err
+		addrLen = net.IPv6len
 	}
 
-	/* Destination port/ICMP code. */
-	if err := read(reader, &event.DportIcode); err != nil {
-		return nil, err
+	// The fields up to and including Priority, the addresses and the
+	// fields up to and including Blocked.
+	if len(data) < 36+2*addrLen+8 {
+		return newDecodeError(eventType, "short event header")
 	}
 
-	/* Protocol. */
-	if err := read(reader, &event.Protocol); err != nil {
-		return nil, err
+	ipSource, ipDestination := dst.IpSource, dst.IpDestination
+	event := dst
+	*event = EventRecord{
+		SensorId:          binary.BigEndian.Uint32(data[0:]),
+		EventId:           binary.BigEndian.Uint32(data[4:]),
+		EventSecond:       binary.BigEndian.Uint32(data[8:]),
+		EventMicrosecond:  binary.BigEndian.Uint32(data[12:]),
+		SignatureId:       binary.BigEndian.Uint32(data[16:]),
+		GeneratorId:       binary.BigEndian.Uint32(data[20:]),
+		SignatureRevision: binary.BigEndian.Uint32(data[24:]),
+		ClassificationId:  binary.BigEndian.Uint32(data[28:]),
+		Priority:          binary.BigEndian.Uint32(data[32:]),
 	}
+	offset := 36
 
-	/* Impact flag. */
-	if err := read(reader, &event.ImpactFlag); err != nil {
-		return nil, err
+	/* Source and destination IP addresses. */
+	if addrLen > 0 {
+		if cap(ipSource) < addrLen || cap(ipDestination) < addrLen {
+			addrs := make([]byte, 2*addrLen)
+			ipSource = addrs[:addrLen:addrLen]
+			ipDestination = addrs[addrLen:]
+		}
+		event.IpSource = ipSource[:addrLen]
+		event.IpDestination = ipDestination[:addrLen]
+		copy(event.IpSource, data[offset:])
+		copy(event.IpDestination, data[offset+addrLen:])
+		offset += 2 * addrLen
 	}
 
-	/* Impact. */
-	if err := read(reader, &event.Impact); err != nil {
-		return nil, err
-	}
+	event.SportItype = binary.BigEndian.Uint16(data[offset:])
+	event.DportIcode = binary.BigEndian.Uint16(data[offset+2:])
+	event.Protocol = data[offset+4]
+	event.ImpactFlag = data[offset+5]
+	event.Impact = data[offset+6]
+	event.Blocked = data[offset+7]
+	offset += 8
 
-	/* Blocked. */
-	if err := read(reader, &event.Blocked); err != nil {
-		return nil, err
+	// Some older Snort builds wrote V2 events ending before the MPLS
+	// and VLAN fields, these are left zero.
+	if (eventType == UNIFIED2_EVENT_V2 || eventType == UNIFIED2_EVENT_V2_IP6) &&
+		len(data) == offset {
+		return nil
 	}
 
 	switch eventType {
@@ -155,125 +181,197 @@ func DecodeEventRecord(eventType uint32, data []byte) (*EventRecord, error) {
 		UNIFIED2_EVENT_V2_IP6,
 		UNIFIED2_EVENT_APPID,
 		UNIFIED2_EVENT_APPID_IP6:
-
-		/* MplsLabel. */
-		if err := read(reader, &event.MplsLabel); err != nil {
-			return nil, err
+		if len(data) < offset+8 {
+			return newDecodeError(eventType, "short event v2 fields")
 		}
+		event.MplsLabel = binary.BigEndian.Uint32(data[offset:])
+		event.VlanId = binary.BigEndian.Uint16(data[offset+4:])
+		event.Pad2 = binary.BigEndian.Uint16(data[offset+6:])
+		offset += 8
+	}
 
-		/* VlanId. */
-		if err := read(reader, &event.VlanId); err != nil {
-			return nil, err
+	/* Inner VLAN id, if enabled and the record is exactly long enough
+	 * to contain it.  Other data left over is placed in the Trailer. */
+	switch eventType {
+	case UNIFIED2_EVENT_V2, UNIFIED2_EVENT_V2_IP6:
+		if DecodeInnerVlan && len(data) == offset+innerVlanLen {
+			event.InnerVlanId = binary.BigEndian.Uint16(data[offset:])
+			event.hasInnerVlan = true
+			offset += innerVlanLen
 		}
+	}
 
-		/* Pad2. */
-		if err := read(reader, &event.Pad2); err != nil {
-			return nil, err
+	switch eventType {
+	case UNIFIED2_EVENT_APPID, UNIFIED2_EVENT_APPID_IP6:
+		appid := data[offset:]
+		if len(appid) > appIdLen {
+			appid = appid[:appIdLen]
+		}
+		offset += len(appid)
+		if end := bytes.IndexByte(appid, 0); end >= 0 {
+			appid = appid[:end]
 		}
+		event.AppId = string(appid)
 	}
 
-	// Any remaining data is the appid.
-	appid := make([]byte, 64)
-	n, err := reader.Read(appid)
-	if err == nil {
-		end := bytes.IndexByte(appid, 0)
-		if end < 0 {
-			end = n
-		}
-		event.AppId = string(appid[0:end])
+	// Any remaining data is beyond the layout we know about.
+	if len(data) > offset {
+		event.Trailer = data[offset:]
 	}
 
-	return event, nil
+	return nil
 }
 
 // DecodePacketRecord decodes a raw unified2 record into a
 // PacketRecord.
-func DecodePacketRecord(data []byte) (packet *PacketRecord, err error) {
-
-	packet = &PacketRecord{}
-
-	reader := bytes.NewBuffer(data)
-
-	if err = read(reader, &packet.SensorId); err != nil {
-		goto error
-	}
-
-	if err = read(reader, &packet.EventId); err != nil {
-		goto error
-	}
-
-	if err = read(reader, &packet.EventSecond); err != nil {
-		goto error
+//
+// Data holds the Length bytes of packet data following the packet
+// header, or all of them if there are fewer, and Trailer any bytes
+// beyond Length.  Both refer to the memory of data, they are not
+// copied.  The packet is only valid as long as data is not modified or
+// reused, use Copy or DecodePacketRecordCopy to keep a packet beyond
+// that.
+func DecodePacketRecord(data []byte) (*PacketRecord, error) {
+	packet := &PacketRecord{}
+	if err := DecodePacketRecordInto(data, packet); err != nil {
+		return nil, err
 	}
+	return packet, nil
+}
 
-	if err = read(reader, &packet.PacketSecond); err != nil {
-		goto error
+// DecodePacketRecordCopy decodes a raw packet record as
+// DecodePacketRecord, but with the Data and Trailer copied so the
+// packet does not share memory with data and remains valid if data is
+// reused.
+func DecodePacketRecordCopy(data []byte) (*PacketRecord, error) {
+	packet, err := DecodePacketRecord(data)
+	if err != nil {
+		return nil, err
 	}
+	return packet.Copy(), nil
+}
 
-	if err = read(reader, &packet.PacketMicrosecond); err != nil {
-		goto error
+// DecodePacketRecordInto decodes a raw packet record into dst, as
+// DecodePacketRecord, to avoid allocating a new PacketRecord for every
+// record.  All fields of dst are overwritten, with Data and Trailer
+// referring to the memory of data.
+func DecodePacketRecordInto(data []byte, dst *PacketRecord) error {
+	if len(data) < PACKET_RECORD_HDR_LEN {
+		return newDecodeError(UNIFIED2_PACKET, "short packet header")
 	}
 
-	if err = read(reader, &packet.LinkType); err != nil {
-		goto error
+	packet := dst
+	*packet = PacketRecord{
+		SensorId:          binary.BigEndian.Uint32(data[0:]),
+		EventId:           binary.BigEndian.Uint32(data[4:]),
+		EventSecond:       binary.BigEndian.Uint32(data[8:]),
+		PacketSecond:      binary.BigEndian.Uint32(data[12:]),
+		PacketMicrosecond: binary.BigEndian.Uint32(data[16:]),
+		LinkType:          binary.BigEndian.Uint32(data[20:]),
+		Length:            binary.BigEndian.Uint32(data[24:]),
+		Data:              data[PACKET_RECORD_HDR_LEN:],
 	}
 
-	if err = read(reader, &packet.Length); err != nil {
-		goto error
+	if uint32(len(packet.Data)) > packet.Length {
+		packet.Trailer = packet.Data[packet.Length:]
+		packet.Data = packet.Data[:packet.Length]
 	}
 
-	packet.Data = data[PACKET_RECORD_HDR_LEN:]
-
-	return packet, nil
-
-error:
-	return nil, DecodingError
+	return nil
 }
 
 // DecodeExtraDataRecord decodes a raw extra data record into an
 // ExtraDataRecord.
-func DecodeExtraDataRecord(data []byte) (extra *ExtraDataRecord, err error) {
-
-	extra = &ExtraDataRecord{}
-
-	reader := bytes.NewBuffer(data)
-
-	if err = read(reader, &extra.EventType); err != nil {
-		goto error
-	}
-
-	if err = read(reader, &extra.EventLength); err != nil {
-		goto error
-	}
-
-	if err = read(reader, &extra.SensorId); err != nil {
-		goto error
-	}
-
-	if err = read(reader, &extra.EventId); err != nil {
-		goto error
-	}
-
-	if err = read(reader, &extra.EventSecond); err != nil {
-		goto error
-	}
-
-	if err = read(reader, &extra.Type); err != nil {
-		goto error
-	}
-
-	if err = read(reader, &extra.DataType); err != nil {
-		goto error
+//
+// The Data and Trailer of the returned record refer to the memory of
+// data, they are not copied.  Use Copy to keep the record beyond the
+// lifetime of data.
+func DecodeExtraDataRecord(data []byte) (*ExtraDataRecord, error) {
+	extra := &ExtraDataRecord{}
+	if err := DecodeExtraDataRecordInto(data, extra); err != nil {
+		return nil, err
 	}
+	return extra, nil
+}
 
-	if err = read(reader, &extra.DataLength); err != nil {
-		goto error
+// DecodeExtraDataRecordInto decodes a raw extra data record into dst,
+// as DecodeExtraDataRecord, to avoid allocating a new ExtraDataRecord
+// for every record.  All fields of dst are overwritten, with Data and
+// Trailer referring to the memory of data.
+func DecodeExtraDataRecordInto(data []byte, dst *ExtraDataRecord) error {
+	if len(data) < EXTRA_DATA_RECORD_HDR_LEN {
+		return newDecodeError(UNIFIED2_EXTRA_DATA, "short extra data header")
+	}
+
+	extra := dst
+	*extra = ExtraDataRecord{
+		EventType:   binary.BigEndian.Uint32(data[0:]),
+		EventLength: binary.BigEndian.Uint32(data[4:]),
+		SensorId:    binary.BigEndian.Uint32(data[8:]),
+		EventId:     binary.BigEndian.Uint32(data[12:]),
+		EventSecond: binary.BigEndian.Uint32(data[16:]),
+		Type:        binary.BigEndian.Uint32(data[20:]),
+		DataType:    binary.BigEndian.Uint32(data[24:]),
+		DataLength:  binary.BigEndian.Uint32(data[28:]),
+		Data:        data[EXTRA_DATA_RECORD_HDR_LEN:],
+	}
+
+	if extra.DataLength >= extraDataLengthOverhead {
+		length := extra.DataLength - extraDataLengthOverhead
+		if uint32(len(extra.Data)) > length {
+			extra.Trailer = extra.Data[length:]
+			extra.Data = extra.Data[:length]
+		}
 	}
 
-	extra.Data = data[EXTRA_DATA_RECORD_HDR_LEN:]
-
-	return extra, nil
+	return nil
+}
 
-error:
-	return nil, DecodingError
+// DecodeEventAuto decodes an event record inferring the event type
+// from the length of the record data rather than the record header.
+//
+// This is useful for files produced by tools that set the wrong
+// record type, but otherwise write a correct event body.  The
+// inferred type is returned along with the decoded event.
+//
+// The body lengths used to infer the event type are:
+//
+//	52  UNIFIED2_EVENT
+//	60  UNIFIED2_EVENT_V2
+//	64  UNIFIED2_EVENT_V2 with inner VLAN, see DecodeInnerVlan
+//	76  UNIFIED2_EVENT_IP6
+//	84  UNIFIED2_EVENT_V2_IP6
+//	88  UNIFIED2_EVENT_V2_IP6 with inner VLAN, see DecodeInnerVlan
+//	124 UNIFIED2_EVENT_APPID
+//	148 UNIFIED2_EVENT_APPID_IP6
+//
+// If the length does not match any of the known layouts a DecodeError
+// is returned.
+func DecodeEventAuto(data []byte) (*EventRecord, uint32, error) {
+	var eventType uint32
+
+	switch len(data) {
+	case 52:
+		eventType = UNIFIED2_EVENT
+	case 60, 64:
+		eventType = UNIFIED2_EVENT_V2
+	case 76:
+		eventType = UNIFIED2_EVENT_IP6
+	case 84, 88:
+		eventType = UNIFIED2_EVENT_V2_IP6
+	case 124:
+		eventType = UNIFIED2_EVENT_APPID
+	case 148:
+		eventType = UNIFIED2_EVENT_APPID_IP6
+	default:
+		return nil, 0, newDecodeError(0, fmt.Sprintf(
+			"event length %d matches no known layout", len(data)))
+	}
+
+	event, err := DecodeEventRecord(eventType, data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return event, eventType, nil
 }
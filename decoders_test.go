@@ -0,0 +1,492 @@
+package unified2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// Reads the first raw record from test/multi-record-event.log, which
+// is known to be an IPv4 V2 event.
+func readFirstEvent(t *testing.T) *RawRecord {
+	input, err := os.Open("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	raw, err := ReadRawRecord(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Type != UNIFIED2_EVENT_V2 {
+		t.Fatalf("expected record type %d, got %d", UNIFIED2_EVENT_V2, raw.Type)
+	}
+	return raw
+}
+
+func TestDecodeEventAuto(t *testing.T) {
+	raw := readFirstEvent(t)
+
+	expected, err := DecodeEventRecord(raw.Type, raw.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, eventType, err := DecodeEventAuto(raw.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eventType != UNIFIED2_EVENT_V2 {
+		t.Fatalf("expected inferred type %d, got %d", UNIFIED2_EVENT_V2, eventType)
+	}
+	if !reflect.DeepEqual(event, expected) {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+
+	// Strip the V2 fields, it should now decode as a V1 event.
+	event, eventType, err = DecodeEventAuto(raw.Data[:52])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eventType != UNIFIED2_EVENT {
+		t.Fatalf("expected inferred type %d, got %d", UNIFIED2_EVENT, eventType)
+	}
+	if event.SignatureId != expected.SignatureId {
+		t.Fatalf("expected signature id %d, got %d", expected.SignatureId,
+			event.SignatureId)
+	}
+
+	_, _, err = DecodeEventAuto(raw.Data[:50])
+	if !errors.Is(err, DecodingError) {
+		t.Fatalf("expected DecodingError, got %v", err)
+	}
+}
+
+func TestDecodeTrailer(t *testing.T) {
+	raw := readFirstEvent(t)
+
+	event, err := DecodeEventRecord(raw.Type, raw.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Trailer) != 0 {
+		t.Fatalf("expected empty trailer, got %v", event.Trailer)
+	}
+
+	data := append(append([]byte{}, raw.Data...), 0xde, 0xad)
+	event, err = DecodeEventRecord(raw.Type, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(event.Trailer, []byte{0xde, 0xad}) {
+		t.Fatalf("unexpected trailer: %v", event.Trailer)
+	}
+	if event.AppId != "" {
+		t.Fatalf("unexpected appid: %s", event.AppId)
+	}
+
+	packet := make([]byte, PACKET_RECORD_HDR_LEN+6)
+	packet[27] = 4 // Length
+	decoded, err := DecodePacketRecord(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Data) != 4 || len(decoded.Trailer) != 2 {
+		t.Fatalf("unexpected data/trailer lengths: %d/%d", len(decoded.Data),
+			len(decoded.Trailer))
+	}
+
+	extra := make([]byte, EXTRA_DATA_RECORD_HDR_LEN+6)
+	extra[31] = extraDataLengthOverhead + 5 // DataLength
+	decodedExtra, err := DecodeExtraDataRecord(extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decodedExtra.Data) != 5 || len(decodedExtra.Trailer) != 1 {
+		t.Fatalf("unexpected data/trailer lengths: %d/%d",
+			len(decodedExtra.Data), len(decodedExtra.Trailer))
+	}
+}
+
+func TestDecodeInnerVlan(t *testing.T) {
+	defer func(enabled bool) { DecodeInnerVlan = enabled }(DecodeInnerVlan)
+	DecodeInnerVlan = true
+	raw := readFirstEvent(t)
+
+	event, err := DecodeEventRecord(raw.Type, raw.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.HasInnerVlan() {
+		t.Fatal("did not expect an inner vlan")
+	}
+
+	data := append(append([]byte{}, raw.Data...), 0x00, 0x2a, 0x00, 0x00)
+	event, eventType, err := DecodeEventAuto(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eventType != UNIFIED2_EVENT_V2 {
+		t.Fatalf("expected inferred type %d, got %d", UNIFIED2_EVENT_V2, eventType)
+	}
+	if !event.HasInnerVlan() || event.InnerVlanId != 42 {
+		t.Fatalf("expected inner vlan 42, got %d", event.InnerVlanId)
+	}
+	if len(event.Trailer) != 0 {
+		t.Fatalf("unexpected trailer: %v", event.Trailer)
+	}
+
+	// Data longer than the inner VLAN layout is all left in the
+	// Trailer.
+	data = append(append([]byte{}, raw.Data...), 0x00, 0x2a, 0x00, 0x00, 0xff)
+	event, err = DecodeEventRecord(raw.Type, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.HasInnerVlan() || len(event.Trailer) != 5 {
+		t.Fatalf("expected a trailer of 5 bytes, got %v", event.Trailer)
+	}
+}
+
+// A V2 event followed by 4 unknown bytes is not taken as carrying an
+// inner VLAN unless enabled.
+func TestDecodeEventTrailerNotInnerVlan(t *testing.T) {
+	raw := readFirstEvent(t)
+
+	data := append(append([]byte{}, raw.Data...), 0xde, 0xad, 0xbe, 0xef)
+	event, err := DecodeEventRecord(raw.Type, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.HasInnerVlan() || event.InnerVlanId != 0 {
+		t.Fatalf("did not expect an inner vlan, got %d", event.InnerVlanId)
+	}
+	if !bytes.Equal(event.Trailer, []byte{0xde, 0xad, 0xbe, 0xef}) {
+		t.Fatalf("expected the bytes in the trailer, got %v", event.Trailer)
+	}
+
+	layout, err := RecordLayout(raw.Type, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.Len != len(raw.Data) {
+		t.Fatalf("expected layout length %d, got %d", len(raw.Data), layout.Len)
+	}
+}
+
+// referenceDecodeEvent decodes an event field by field with
+// binary.Read, as a reference for DecodeEventRecord.
+func referenceDecodeEvent(eventType uint32, data []byte) (*EventRecord, error) {
+	event := &EventRecord{}
+	reader := bytes.NewBuffer(data)
+	read := func(fields ...interface{}) error {
+		for _, field := range fields {
+			if err := binary.Read(reader, binary.BigEndian, field); err != nil {
+				return DecodingError
+			}
+		}
+		return nil
+	}
+
+	err := read(&event.SensorId, &event.EventId, &event.EventSecond,
+		&event.EventMicrosecond, &event.SignatureId, &event.GeneratorId,
+		&event.SignatureRevision, &event.ClassificationId, &event.Priority)
+	if err != nil {
+		return nil, err
+	}
+
+	switch eventType {
+	case UNIFIED2_EVENT, UNIFIED2_EVENT_V2, UNIFIED2_EVENT_APPID:
+		event.IpSource = make([]byte, 4)
+		event.IpDestination = make([]byte, 4)
+	case UNIFIED2_EVENT_IP6, UNIFIED2_EVENT_V2_IP6, UNIFIED2_EVENT_APPID_IP6:
+		event.IpSource = make([]byte, 16)
+		event.IpDestination = make([]byte, 16)
+	}
+	if event.IpSource != nil {
+		if err := read(&event.IpSource, &event.IpDestination); err != nil {
+			return nil, err
+		}
+	}
+
+	err = read(&event.SportItype, &event.DportIcode, &event.Protocol,
+		&event.ImpactFlag, &event.Impact, &event.Blocked)
+	if err != nil {
+		return nil, err
+	}
+
+	switch eventType {
+	case UNIFIED2_EVENT_V2, UNIFIED2_EVENT_V2_IP6:
+		if reader.Len() == 0 {
+			return event, nil
+		}
+	}
+
+	switch eventType {
+	case UNIFIED2_EVENT_V2, UNIFIED2_EVENT_V2_IP6,
+		UNIFIED2_EVENT_APPID, UNIFIED2_EVENT_APPID_IP6:
+		if err := read(&event.MplsLabel, &event.VlanId, &event.Pad2); err != nil {
+			return nil, err
+		}
+	}
+
+	switch eventType {
+	case UNIFIED2_EVENT_V2, UNIFIED2_EVENT_V2_IP6:
+		if DecodeInnerVlan && reader.Len() == 4 {
+			var pad uint16
+			if err := read(&event.InnerVlanId, &pad); err != nil {
+				return nil, err
+			}
+			event.hasInnerVlan = true
+		}
+	}
+
+	switch eventType {
+	case UNIFIED2_EVENT_APPID, UNIFIED2_EVENT_APPID_IP6:
+		appid := make([]byte, 64)
+		n, _ := reader.Read(appid)
+		if end := bytes.IndexByte(appid[:n], 0); end >= 0 {
+			n = end
+		}
+		event.AppId = string(appid[:n])
+	}
+
+	if reader.Len() > 0 {
+		event.Trailer = reader.Bytes()
+	}
+
+	return event, nil
+}
+
+// DecodeEventRecord must decode every event layout the same as the
+// field by field reference decoder, including the error for short
+// data.
+func TestDecodeEventRecordReference(t *testing.T) {
+	raw := readFirstEvent(t)
+
+	// Build the data of each event type from the V2 test event, with
+	// IPv6 addresses, an appid and inner vlan where applicable.
+	v4 := raw.Data[:52]
+	v6 := append(append(append([]byte{}, raw.Data[:36]...),
+		bytes.Repeat([]byte{0x20, 0x01}, 16)...), raw.Data[44:52]...)
+	v2 := raw.Data[52:60]
+	appid := append([]byte("appid"), make([]byte, 59)...)
+	events := map[uint32][]byte{
+		UNIFIED2_EVENT:           v4,
+		UNIFIED2_EVENT_IP6:       v6,
+		UNIFIED2_EVENT_V2:        concat(v4, v2),
+		UNIFIED2_EVENT_V2_IP6:    concat(v6, v2, []byte{0, 42, 0, 0}),
+		UNIFIED2_EVENT_APPID:     concat(v4, v2, appid),
+		UNIFIED2_EVENT_APPID_IP6: concat(v6, v2, appid[:10]),
+	}
+
+	for eventType, data := range events {
+		for _, length := range []int{0, 10, 51, 52, 53, 76, 77, len(data) - 1, len(data)} {
+			if length > len(data) {
+				continue
+			}
+			expected, expectedErr := referenceDecodeEvent(eventType, data[:length])
+			event, err := DecodeEventRecord(eventType, data[:length])
+			if errors.Is(err, DecodingError) != (expectedErr != nil) {
+				t.Fatalf("type %d, length %d: expected error %v, got %v",
+					eventType, length, expectedErr, err)
+			}
+			if !reflect.DeepEqual(event, expected) {
+				t.Fatalf("type %d, length %d: expected %+v, got %+v",
+					eventType, length, expected, event)
+			}
+		}
+	}
+}
+
+// Decoding into a reused event must give the same result as decoding
+// into a new event, also when the address length changes.
+func TestDecodeEventRecordInto(t *testing.T) {
+	raw := readFirstEvent(t)
+	v6 := append(append(append([]byte{}, raw.Data[:36]...),
+		bytes.Repeat([]byte{0x20, 0x01}, 16)...), raw.Data[44:60]...)
+
+	events := []struct {
+		eventType uint32
+		data      []byte
+	}{
+		{UNIFIED2_EVENT_V2, raw.Data},
+		{UNIFIED2_EVENT_V2_IP6, v6},
+		{UNIFIED2_EVENT_V2, raw.Data},
+	}
+
+	dst := &EventRecord{}
+	for _, e := range events {
+		expected, err := DecodeEventRecord(e.eventType, e.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := DecodeEventRecordInto(e.eventType, e.data, dst); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(dst, expected) {
+			t.Fatalf("expected %+v, got %+v", expected, dst)
+		}
+	}
+
+	if err := DecodeEventRecordInto(UNIFIED2_EVENT_V2, raw.Data[:10], dst); !errors.Is(err, DecodingError) {
+		t.Fatalf("expected DecodingError, got %v", err)
+	}
+}
+
+func concat(slices ...[]byte) []byte {
+	var data []byte
+	for _, slice := range slices {
+		data = append(data, slice...)
+	}
+	return data
+}
+
+func BenchmarkDecodeEventRecord(b *testing.B) {
+	raw, err := ReadRawRecord(bytes.NewReader(benchmarkEvent(b)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		DecodeEventRecord(raw.Type, raw.Data)
+	}
+}
+
+func BenchmarkDecodeEventRecordInto(b *testing.B) {
+	raw, err := ReadRawRecord(bytes.NewReader(benchmarkEvent(b)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	event := &EventRecord{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		DecodeEventRecordInto(raw.Type, raw.Data, event)
+	}
+}
+
+// BenchmarkDecodeEventRecordReference is for comparison with
+// BenchmarkDecodeEventRecord.
+func BenchmarkDecodeEventRecordReference(b *testing.B) {
+	raw, err := ReadRawRecord(bytes.NewReader(benchmarkEvent(b)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		referenceDecodeEvent(raw.Type, raw.Data)
+	}
+}
+
+// benchmarkEvent returns the first event of the test file.
+func benchmarkEvent(b *testing.B) []byte {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return buf[:68]
+}
+
+// corruptLog returns the test file with a packet record too short for
+// the packet header inserted after the first event, at offset 68.
+func corruptLog(t *testing.T) []byte {
+	data, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := []byte{0, 0, 0, UNIFIED2_PACKET, 0, 0, 0, 4, 1, 2, 3, 4}
+	return concat(data[:68], corrupt, data[68:])
+}
+
+func TestDecodeErrorFields(t *testing.T) {
+	file := bytes.NewReader(corruptLog(t))
+	if _, err := ReadRecord(file); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ReadRecord(file)
+	if !errors.Is(err, DecodingError) || !errors.Is(err, ErrMalformedRecord) {
+		t.Fatalf("expected DecodingError and ErrMalformedRecord, got %v", err)
+	}
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected DecodeError, got %v", err)
+	}
+	if decodeErr.Type != UNIFIED2_PACKET || decodeErr.Offset != 68 ||
+		decodeErr.Reason != "record length 4 below minimum of 28" {
+		t.Fatalf("unexpected error %+v", decodeErr)
+	}
+
+	// The corrupt record has been consumed.
+	if _, err := ReadRecord(file); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = DecodeExtraDataRecord(make([]byte, 10))
+	if !errors.As(err, &decodeErr) || decodeErr.Type != UNIFIED2_EXTRA_DATA ||
+		decodeErr.Offset != -1 {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+// V2 events written without the MPLS and VLAN fields decode with those
+// fields zero, but V2 fields present in part are an error.
+func TestDecodeEventV2WithoutMpls(t *testing.T) {
+	input, err := os.Open("test/event-v2-no-mpls.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	raw, err := ReadRawRecord(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Type != UNIFIED2_EVENT_V2 || len(raw.Data) != 52 {
+		t.Fatalf("unexpected record type %d, length %d", raw.Type, len(raw.Data))
+	}
+
+	event, err := DecodeEventRecord(raw.Type, raw.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.EventId != 89 || event.SignatureId != 3 || event.SportItype != 80 ||
+		event.MplsLabel != 0 || event.VlanId != 0 || event.HasInnerVlan() {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	partial := append(append([]byte{}, raw.Data...), 0, 0, 0, 1)
+	if _, err := DecodeEventRecord(raw.Type, partial); !errors.Is(err, DecodingError) {
+		t.Fatalf("expected DecodingError, got %v", err)
+	}
+}
+
+func TestDecodePacketRecordCopy(t *testing.T) {
+	data := make([]byte, PACKET_RECORD_HDR_LEN+6)
+	data[27] = 4 // Length
+	copy(data[PACKET_RECORD_HDR_LEN:], "abcdef")
+
+	packet, err := DecodePacketRecordCopy(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(packet.Data) != "abcd" || string(packet.Trailer) != "ef" {
+		t.Fatalf("unexpected data/trailer: %q/%q", packet.Data, packet.Trailer)
+	}
+
+	// The packet does not share memory with data.
+	copy(data[PACKET_RECORD_HDR_LEN:], "xxxxxx")
+	if string(packet.Data) != "abcd" || string(packet.Trailer) != "ef" {
+		t.Fatalf("unexpected data/trailer after reuse: %q/%q", packet.Data,
+			packet.Trailer)
+	}
+
+	if _, err := DecodePacketRecordCopy(data[:10]); !errors.Is(err, DecodingError) {
+		t.Fatalf("expected DecodingError, got %v", err)
+	}
+}
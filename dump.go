@@ -0,0 +1,115 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// The number of bytes of data shown by Dump.
+const dumpPreviewLen = 64
+
+// Dump writes a human readable listing of the record held by c to w,
+// for debugging and golden tests.
+//
+// The record type name and number are written on the first line,
+// followed by each field of the record, in the order of the record
+// struct, as an indented "Name: value" line.  IP addresses are written
+// as strings, byte slices as their length followed by a hex dump of up
+// to the first 64 bytes, and the time of the record, in UTC and RFC
+// 3339 format with microseconds, as a final Timestamp line.  The
+// format does not change between versions except for the addition of
+// new fields.
+func Dump(w io.Writer, c *RecordContainer) error {
+	var buf bytes.Buffer
+
+	value := reflect.ValueOf(c.Record)
+	if value.Kind() != reflect.Ptr || value.IsNil() ||
+		value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("unsupported record %T", c.Record)
+	}
+	value = value.Elem()
+
+	fmt.Fprintf(&buf, "%s (type %d)\n", value.Type().Name(), c.Type)
+	if c.SourceName != "" {
+		fmt.Fprintf(&buf, "  SourceName: %s\n", c.SourceName)
+	}
+
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		switch fieldValue := value.Field(i).Interface().(type) {
+		case net.IP:
+			if fieldValue == nil {
+				fmt.Fprintf(&buf, "  %s: \n", field.Name)
+			} else {
+				fmt.Fprintf(&buf, "  %s: %s\n", field.Name, fieldValue)
+			}
+		case []byte:
+			fmt.Fprintf(&buf, "  %s: %d bytes\n", field.Name, len(fieldValue))
+			dumpBytes(&buf, fieldValue)
+		case string:
+			fmt.Fprintf(&buf, "  %s: %q\n", field.Name, fieldValue)
+		default:
+			fmt.Fprintf(&buf, "  %s: %v\n", field.Name, fieldValue)
+		}
+	}
+
+	if record, ok := c.Record.(interface{ Timestamp() time.Time }); ok {
+		fmt.Fprintf(&buf, "  Timestamp: %s\n",
+			record.Timestamp().UTC().Format("2006-01-02T15:04:05.000000Z07:00"))
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// dumpBytes writes an indented hex dump of up to dumpPreviewLen bytes
+// of data to buf.
+func dumpBytes(buf *bytes.Buffer, data []byte) {
+	preview := data
+	if len(preview) > dumpPreviewLen {
+		preview = preview[:dumpPreviewLen]
+	}
+	for _, line := range strings.SplitAfter(hex.Dump(preview), "\n") {
+		if line != "" {
+			buf.WriteString("    " + line)
+		}
+	}
+	if len(data) > len(preview) {
+		buf.WriteString("    ...\n")
+	}
+}
@@ -0,0 +1,41 @@
+package unified2
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	input, err := os.Open("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	// Dump the event, extra data and first packet.
+	var buf bytes.Buffer
+	for i := 0; i < 3; i++ {
+		raw, err := ReadRawRecord(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		container, err := DecodeRawRecord(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Dump(&buf, container); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	golden, err := ioutil.ReadFile("test/multi-record-event.dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), golden) {
+		t.Fatalf("dump does not match test/multi-record-event.dump:\n%s",
+			buf.String())
+	}
+}
@@ -0,0 +1,269 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EncodingError is the error returned if a record can not be encoded.
+var EncodingError = errors.New("EncodingError")
+
+// The length of the AppId field of application id events.
+const appIdLen = 64
+
+// Helper function for writing binary data as all writes are big
+// endian.
+func write(writer io.Writer, data interface{}) {
+	binary.Write(writer, binary.BigEndian, data)
+}
+
+// EncodeEventRecord encodes an event into a raw event record, the
+// inverse of DecodeEventRecord.
+//
+// The eventType selects the layout of the record: UNIFIED2_EVENT,
+// UNIFIED2_EVENT_V2 or UNIFIED2_EVENT_APPID, or their IPv6 variants.
+// Whether the IPv4 or IPv6 variant is used is decided by the length of
+// IpSource, 4 or 16 bytes, so an event with addresses from net.ParseIP
+// is encoded as IPv6 unless the addresses are converted with To4.  An
+// EncodingError is returned if the event type is unknown or the
+// addresses are not both 4 or 16 bytes.
+func EncodeEventRecord(eventType uint32, event *EventRecord) (*RawRecord, error) {
+	addrLen := len(event.IpSource)
+	if len(event.IpDestination) != addrLen {
+		return nil, fmt.Errorf("%w: source and destination address lengths differ",
+			EncodingError)
+	}
+
+	switch addrLen {
+	case 4:
+		switch eventType {
+		case UNIFIED2_EVENT_IP6:
+			eventType = UNIFIED2_EVENT
+		case UNIFIED2_EVENT_V2_IP6:
+			eventType = UNIFIED2_EVENT_V2
+		case UNIFIED2_EVENT_APPID_IP6:
+			eventType = UNIFIED2_EVENT_APPID
+		}
+	case 16:
+		switch eventType {
+		case UNIFIED2_EVENT:
+			eventType = UNIFIED2_EVENT_IP6
+		case UNIFIED2_EVENT_V2:
+			eventType = UNIFIED2_EVENT_V2_IP6
+		case UNIFIED2_EVENT_APPID:
+			eventType = UNIFIED2_EVENT_APPID_IP6
+		}
+	default:
+		return nil, fmt.Errorf("%w: invalid address length %d", EncodingError,
+			addrLen)
+	}
+	if !isEventType(eventType) {
+		return nil, fmt.Errorf("%w: unknown event type %d", EncodingError,
+			eventType)
+	}
+
+	var buf bytes.Buffer
+
+	write(&buf, []uint32{
+		event.SensorId,
+		event.EventId,
+		event.EventSecond,
+		event.EventMicrosecond,
+		event.SignatureId,
+		event.GeneratorId,
+		event.SignatureRevision,
+		event.ClassificationId,
+		event.Priority,
+	})
+	buf.Write(event.IpSource)
+	buf.Write(event.IpDestination)
+	write(&buf, []uint16{event.SportItype, event.DportIcode})
+	buf.Write([]byte{
+		event.Protocol,
+		event.ImpactFlag,
+		event.Impact,
+		event.Blocked,
+	})
+
+	switch eventType {
+	case UNIFIED2_EVENT_V2,
+		UNIFIED2_EVENT_V2_IP6,
+		UNIFIED2_EVENT_APPID,
+		UNIFIED2_EVENT_APPID_IP6:
+		write(&buf, event.MplsLabel)
+		write(&buf, []uint16{event.VlanId, event.Pad2})
+	}
+
+	switch eventType {
+	case UNIFIED2_EVENT_V2, UNIFIED2_EVENT_V2_IP6:
+		if event.hasInnerVlan {
+			write(&buf, []uint16{event.InnerVlanId, 0})
+		}
+	}
+
+	switch eventType {
+	case UNIFIED2_EVENT_APPID, UNIFIED2_EVENT_APPID_IP6:
+		if len(event.AppId) > appIdLen {
+			return nil, fmt.Errorf("%w: AppId longer than %d bytes",
+				EncodingError, appIdLen)
+		}
+		appid := make([]byte, appIdLen)
+		copy(appid, event.AppId)
+		buf.Write(appid)
+	}
+
+	buf.Write(event.Trailer)
+
+	return &RawRecord{eventType, buf.Bytes()}, nil
+}
+
+// EncodePacketRecord encodes a packet into a raw packet record, the
+// inverse of DecodePacketRecord.  The Length of the packet is written
+// as is.
+func EncodePacketRecord(packet *PacketRecord) *RawRecord {
+	var buf bytes.Buffer
+	buf.Grow(PACKET_RECORD_HDR_LEN + len(packet.Data) + len(packet.Trailer))
+
+	write(&buf, []uint32{
+		packet.SensorId,
+		packet.EventId,
+		packet.EventSecond,
+		packet.PacketSecond,
+		packet.PacketMicrosecond,
+		packet.LinkType,
+		packet.Length,
+	})
+	buf.Write(packet.Data)
+	buf.Write(packet.Trailer)
+
+	return &RawRecord{UNIFIED2_PACKET, buf.Bytes()}
+}
+
+// EncodeExtraDataRecord encodes extra data into a raw extra data
+// record, the inverse of DecodeExtraDataRecord.  The EventLength and
+// DataLength are written as is.
+func EncodeExtraDataRecord(extra *ExtraDataRecord) *RawRecord {
+	var buf bytes.Buffer
+	buf.Grow(EXTRA_DATA_RECORD_HDR_LEN + len(extra.Data) + len(extra.Trailer))
+
+	write(&buf, []uint32{
+		extra.EventType,
+		extra.EventLength,
+		extra.SensorId,
+		extra.EventId,
+		extra.EventSecond,
+		extra.Type,
+		extra.DataType,
+		extra.DataLength,
+	})
+	buf.Write(extra.Data)
+	buf.Write(extra.Trailer)
+
+	return &RawRecord{UNIFIED2_EXTRA_DATA, buf.Bytes()}
+}
+
+// encodeRecord encodes the record held by a RecordContainer into a
+// raw record.
+func encodeRecord(c *RecordContainer) (*RawRecord, error) {
+	switch record := c.Record.(type) {
+	case *EventRecord:
+		return EncodeEventRecord(c.Type, record)
+	case *PacketRecord:
+		return EncodePacketRecord(record), nil
+	case *ExtraDataRecord:
+		return EncodeExtraDataRecord(record), nil
+	}
+	return nil, fmt.Errorf("%w: unsupported record %T", EncodingError,
+		c.Record)
+}
+
+// writeRawRecord writes a raw record, header and data, to out.  The
+// header length is that of the record data.
+func writeRawRecord(out io.Writer, record *RawRecord) error {
+	buf := make([]byte, 0, rawHeaderLen+len(record.Data))
+	_, err := out.Write(appendRawRecord(buf, record))
+	return err
+}
+
+// appendRawRecord appends a raw record, header and data, to buf.
+func appendRawRecord(buf []byte, record *RawRecord) []byte {
+	buf = binary.BigEndian.AppendUint32(buf, record.Type)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(record.Data)))
+	return append(buf, record.Data...)
+}
+
+// WriteRecord encodes the record held by a RecordContainer and writes
+// it, header and data, to w.  The length in the header is that of the
+// encoded record.  For events the Type of the container selects the
+// layout, see EncodeEventRecord.
+func WriteRecord(w io.Writer, container *RecordContainer) error {
+	raw, err := encodeRecord(container)
+	if err != nil {
+		return err
+	}
+	return writeRawRecord(w, raw)
+}
+
+// WriteRecords encodes the records held by containers into a single
+// buffer and writes it to w with one write, avoiding a write per
+// record.
+//
+// The number of records written is returned.  If a record can not be
+// encoded the records before it are written, and an EncodingError
+// naming the index of the record is returned.  If writing to w fails
+// its error is returned with a count of 0.
+func WriteRecords(w io.Writer, containers []*RecordContainer) (int, error) {
+	var encodeErr error
+
+	// Encode all the records first, so the buffer is allocated once.
+	raws := make([]*RawRecord, 0, len(containers))
+	size := 0
+	for i, container := range containers {
+		raw, err := encodeRecord(container)
+		if err != nil {
+			encodeErr = fmt.Errorf("record %d: %w", i, err)
+			break
+		}
+		raws = append(raws, raw)
+		size += rawHeaderLen + len(raw.Data)
+	}
+
+	buf := make([]byte, 0, size)
+	for _, raw := range raws {
+		buf = appendRawRecord(buf, raw)
+	}
+
+	if _, err := w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(raws), encodeErr
+}
@@ -0,0 +1,153 @@
+package unified2
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+)
+
+// readContainers reads all records of a file into RecordContainers.
+func readContainers(t testing.TB, filename string) []*RecordContainer {
+	reader, err := NewRecordReader(filename, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	var containers []*RecordContainer
+	for {
+		container, err := reader.NextContainer()
+		if err != nil {
+			if atEOF(err) {
+				return containers
+			}
+			t.Fatal(err)
+		}
+		containers = append(containers, container)
+	}
+}
+
+func TestWriteRecords(t *testing.T) {
+	input, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	containers := readContainers(t, "test/multi-record-event.log")
+
+	var buf bytes.Buffer
+	n, err := WriteRecords(&buf, containers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(containers) {
+		t.Fatalf("expected %d records written, got %d", len(containers), n)
+	}
+	if !bytes.Equal(buf.Bytes(), input) {
+		t.Fatalf("written records do not match the input")
+	}
+
+	// Encoding stops at an unsupported record.
+	buf.Reset()
+	invalid := append(containers[:2:2], &RecordContainer{Record: "invalid"})
+	n, err = WriteRecords(&buf, invalid)
+	if !errors.Is(err, EncodingError) || n != 2 {
+		t.Fatalf("expected EncodingError after 2 records, got %d, %v", n, err)
+	}
+	if !bytes.HasPrefix(input, buf.Bytes()) || buf.Len() != 18678 {
+		t.Fatalf("expected the first 2 records to be written, got %d bytes",
+			buf.Len())
+	}
+}
+
+func BenchmarkWriteRecords(b *testing.B) {
+	containers := readContainers(b, "test/multi-record-event.log")
+	out, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer out.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := WriteRecords(out, containers); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWriteRecordsUnbuffered writes each record with its own
+// write, for comparison with BenchmarkWriteRecords.
+func BenchmarkWriteRecordsUnbuffered(b *testing.B) {
+	containers := readContainers(b, "test/multi-record-event.log")
+	out, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer out.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, container := range containers {
+			raw, err := encodeRecord(container)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := writeRawRecord(out, raw); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+// Every record of the test file re-encoded with WriteRecord must match
+// the input byte for byte.
+func TestWriteRecordRoundTrip(t *testing.T) {
+	input, err := ioutil.ReadFile("test/multi-record-event-x2.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	for _, container := range readContainers(t, "test/multi-record-event-x2.log") {
+		if err := WriteRecord(&buf, container); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(buf.Bytes(), input) {
+		t.Fatalf("written records do not match the input")
+	}
+}
+
+func TestEncodeEventRecordIPv6(t *testing.T) {
+	event := &EventRecord{
+		SignatureId:   1,
+		IpSource:      net.ParseIP("2001:db8::1"),
+		IpDestination: net.ParseIP("2001:db8::2"),
+		MplsLabel:     7,
+	}
+
+	raw, err := EncodeEventRecord(UNIFIED2_EVENT_V2, event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Type != UNIFIED2_EVENT_V2_IP6 || len(raw.Data) != 84 {
+		t.Fatalf("expected an 84 byte IPv6 event, got type %d, %d bytes",
+			raw.Type, len(raw.Data))
+	}
+
+	decoded, err := DecodeEventRecord(raw.Type, raw.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Equal(event) {
+		t.Fatalf("expected %+v, got %+v", event, decoded)
+	}
+
+	event.IpDestination = event.IpDestination.To4()
+	if _, err := EncodeEventRecord(UNIFIED2_EVENT_V2, event); !errors.Is(err, EncodingError) {
+		t.Fatalf("expected EncodingError, got %v", err)
+	}
+}
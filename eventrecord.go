@@ -0,0 +1,422 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"reflect"
+	"time"
+)
+
+// EventKey identifies an event and the packet and extra data records
+// belonging to it.  EventId alone is not unique across sensors so the
+// SensorId is part of the key.
+type EventKey struct {
+	SensorId uint32
+	EventId  uint32
+}
+
+// Key returns the EventKey of the event.
+func (e *EventRecord) Key() EventKey {
+	return EventKey{e.SensorId, e.EventId}
+}
+
+// Timestamp returns the time of the event as a time.Time in UTC.
+func (e *EventRecord) Timestamp() time.Time {
+	return time.Unix(int64(e.EventSecond),
+		int64(e.EventMicrosecond)*int64(time.Microsecond)).UTC()
+}
+
+// SourceIP returns the source address of the event, or nil if the
+// event has no source address.
+func (e *EventRecord) SourceIP() net.IP {
+	if len(e.IpSource) == 0 {
+		return nil
+	}
+	return e.IpSource
+}
+
+// DestinationIP returns the destination address of the event, or nil
+// if the event has no destination address.
+func (e *EventRecord) DestinationIP() net.IP {
+	if len(e.IpDestination) == 0 {
+		return nil
+	}
+	return e.IpDestination
+}
+
+// IsIPv6 returns true if the event has IPv6 addresses, that is it was
+// decoded from one of the IPv6 event types.
+func (e *EventRecord) IsIPv6() bool {
+	return len(e.IpSource) == net.IPv6len
+}
+
+// String returns a one line summary of the event, with the generator,
+// signature and revision, the addresses, empty if missing, ports if the
+// protocol is TCP or UDP, the protocol and the time of the event.
+func (e *EventRecord) String() string {
+	src := ipString(e.IpSource)
+	dst := ipString(e.IpDestination)
+	if e.Protocol == ipProtoTCP || e.Protocol == ipProtoUDP {
+		src = net.JoinHostPort(src, fmt.Sprint(e.SportItype))
+		dst = net.JoinHostPort(dst, fmt.Sprint(e.DportIcode))
+	}
+	return fmt.Sprintf("event %d [%d:%d:%d] %s -> %s proto %d at %s",
+		e.EventId, e.GeneratorId, e.SignatureId, e.SignatureRevision,
+		src, dst, e.Protocol, jsonTime(e.Timestamp()))
+}
+
+// EventColumnNames returns the names of the columns for the values
+// returned by EventRecord.Columns, in the same order.
+//
+// The names are suitable for use in a prepared INSERT statement, for
+// example:
+//
+//	INSERT INTO events (sensor_id, event_id, ...) VALUES (?, ?, ...)
+func EventColumnNames() []string {
+	return []string{
+		"sensor_id",
+		"event_id",
+		"timestamp",
+		"src_ip",
+		"src_port",
+		"dst_ip",
+		"dst_port",
+		"protocol",
+		"generator_id",
+		"signature_id",
+		"signature_revision",
+		"classification_id",
+		"priority",
+		"blocked",
+	}
+}
+
+// Columns returns the event as a row of values matching the names
+// returned by EventColumnNames.
+//
+// IP addresses are returned as strings, empty if missing, and the
+// timestamp as a time.Time so the values can be passed directly to
+// database/sql.
+func (e *EventRecord) Columns() []interface{} {
+	return []interface{}{
+		e.SensorId,
+		e.EventId,
+		e.Timestamp(),
+		ipString(e.IpSource),
+		e.SportItype,
+		ipString(e.IpDestination),
+		e.DportIcode,
+		e.Protocol,
+		e.GeneratorId,
+		e.SignatureId,
+		e.SignatureRevision,
+		e.ClassificationId,
+		e.Priority,
+		e.Blocked,
+	}
+}
+
+// Copy returns a copy of the event that does not share memory with
+// the event or the data it was decoded from.
+func (e *EventRecord) Copy() *EventRecord {
+	event := *e
+	event.IpSource = net.IP(copyBytes(e.IpSource))
+	event.IpDestination = net.IP(copyBytes(e.IpDestination))
+	event.Trailer = copyBytes(e.Trailer)
+	return &event
+}
+
+// Revision returns the signature revision of the event, and whether
+// the revision is meaningful for the generator of the event.
+//
+// Only rules have a revision: generator 1 for text rules and generator
+// 3 for shared object rules.  For events from the decoder and
+// preprocessors, and other generators, the revision is not set by a
+// rule and false is returned.
+func (e *EventRecord) Revision() (uint32, bool) {
+	switch e.GeneratorId {
+	case 1, 3:
+		return e.SignatureRevision, true
+	}
+	return e.SignatureRevision, false
+}
+
+// HasInnerVlan returns true if the event was decoded from a record
+// carrying an inner VLAN id, see InnerVlanId.
+func (e *EventRecord) HasInnerVlan() bool {
+	return e.hasInnerVlan
+}
+
+// ZeekConnFields returns the connection fields of the event named and
+// formatted as in the Zeek conn.log: id.orig_h, id.orig_p, id.resp_h,
+// id.resp_p and proto.
+//
+// As in Zeek, proto is one of "tcp", "udp", "icmp" (for both ICMP and
+// ICMPv6) or "unknown_transport", and for ICMP the ports hold the
+// ICMP type and code.
+func (e *EventRecord) ZeekConnFields() map[string]interface{} {
+	proto := "unknown_transport"
+	switch e.Protocol {
+	case ipProtoTCP:
+		proto = "tcp"
+	case ipProtoUDP:
+		proto = "udp"
+	case ipProtoICMP, ipProtoICMPv6:
+		proto = "icmp"
+	}
+
+	return map[string]interface{}{
+		"id.orig_h": ipString(e.IpSource),
+		"id.orig_p": e.SportItype,
+		"id.resp_h": ipString(e.IpDestination),
+		"id.resp_p": e.DportIcode,
+		"proto":     proto,
+	}
+}
+
+// ICMP returns the ICMP type and code of an ICMP or ICMPv6 event, ok
+// is false for other protocols.
+//
+// For ICMP events the type and code are logged in SportItype and
+// DportIcode respectively, each as a 16 bit value in network byte
+// order.  The type or code is in the low byte, the high byte is zero.
+func (e *EventRecord) ICMP() (typ uint8, code uint8, ok bool) {
+	if e.Protocol != ipProtoICMP && e.Protocol != ipProtoICMPv6 {
+		return 0, 0, false
+	}
+	return uint8(e.SportItype), uint8(e.DportIcode), true
+}
+
+// Attributes returns the event as a flat map of attributes suitable
+// for an OpenTelemetry log record.
+//
+// The attributes follow the OpenTelemetry semantic conventions where
+// one exists: network.transport (only for TCP and UDP), network.type,
+// source.address, source.port, destination.address and
+// destination.port, with ports only present for TCP and UDP.  The
+// event id is provided as event.id, and the unified2 specific fields
+// under the unified2 namespace: unified2.sensor_id,
+// unified2.generator_id, unified2.signature_id,
+// unified2.signature_revision, unified2.classification_id and
+// unified2.priority.
+func (e *EventRecord) Attributes() map[string]interface{} {
+	attributes := map[string]interface{}{
+		"source.address":              ipString(e.IpSource),
+		"destination.address":         ipString(e.IpDestination),
+		"event.id":                    e.EventId,
+		"unified2.sensor_id":          e.SensorId,
+		"unified2.generator_id":       e.GeneratorId,
+		"unified2.signature_id":       e.SignatureId,
+		"unified2.signature_revision": e.SignatureRevision,
+		"unified2.classification_id":  e.ClassificationId,
+		"unified2.priority":           e.Priority,
+	}
+
+	switch len(e.IpSource) {
+	case net.IPv4len:
+		attributes["network.type"] = "ipv4"
+	case net.IPv6len:
+		attributes["network.type"] = "ipv6"
+	}
+
+	switch e.Protocol {
+	case ipProtoTCP:
+		attributes["network.transport"] = "tcp"
+	case ipProtoUDP:
+		attributes["network.transport"] = "udp"
+	default:
+		return attributes
+	}
+	attributes["source.port"] = e.SportItype
+	attributes["destination.port"] = e.DportIcode
+
+	return attributes
+}
+
+// Equal returns true if all the fields of the events are equal.
+func (e *EventRecord) Equal(other *EventRecord) bool {
+	return e.EqualIgnoring(other)
+}
+
+// EqualIgnoring returns true if the events are equal ignoring the named
+// fields, for example:
+//
+//	a.EqualIgnoring(b, "SensorId", "EventMicrosecond")
+//
+// Field names are the names of the EventRecord struct fields.
+// EqualIgnoring panics if a name is not an EventRecord field, so a typo
+// can't silently make a comparison pass.
+func (e *EventRecord) EqualIgnoring(other *EventRecord, fields ...string) bool {
+	typ := reflect.TypeOf(*e)
+
+	ignore := make(map[string]bool, len(fields))
+	for _, name := range fields {
+		if field, ok := typ.FieldByName(name); !ok || field.PkgPath != "" {
+			panic(fmt.Sprintf("unified2: EventRecord has no field %q", name))
+		}
+		ignore[name] = true
+	}
+
+	a := reflect.ValueOf(*e)
+	b := reflect.ValueOf(*other)
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.PkgPath != "" || ignore[field.Name] {
+			continue
+		}
+		if field.Type.Kind() == reflect.Slice {
+			if !bytes.Equal(a.Field(i).Bytes(), b.Field(i).Bytes()) {
+				return false
+			}
+		} else if a.Field(i).Interface() != b.Field(i).Interface() {
+			return false
+		}
+	}
+
+	if !ignore["InnerVlanId"] && e.hasInnerVlan != other.hasInnerVlan {
+		return false
+	}
+
+	return true
+}
+
+// ImpactFlag bits.  Snort itself only sets IMPACT_FLAG_BLOCKED (its
+// U2_FLAG_BLOCKED), the other bits are set by Sourcefire/Firepower
+// systems and are defined in the eStreamer Integration Guide.
+const (
+	// Source or destination host is in a monitored network.
+	IMPACT_FLAG_MONITORED_NETWORK = 0x01
+
+	// Source or destination host exists in the network map.
+	IMPACT_FLAG_HOST_IN_NETWORK_MAP = 0x02
+
+	// Source or destination host is running a server on the port of
+	// the event (TCP or UDP) or uses the IP protocol.
+	IMPACT_FLAG_SERVER_ON_PORT = 0x04
+
+	// There is a vulnerability mapped to the operating system of the
+	// source or destination host.
+	IMPACT_FLAG_OS_VULNERABILITY = 0x08
+
+	// There is a vulnerability mapped to the server detected in the
+	// event.
+	IMPACT_FLAG_SERVER_VULNERABILITY = 0x10
+
+	// The packet or session was dropped.
+	IMPACT_FLAG_BLOCKED = 0x20
+
+	// The source or destination host is potentially compromised.
+	IMPACT_FLAG_COMPROMISED = 0x40
+)
+
+var impactFlagNames = []struct {
+	flag uint8
+	name string
+}{
+	{IMPACT_FLAG_MONITORED_NETWORK, "monitored-network"},
+	{IMPACT_FLAG_HOST_IN_NETWORK_MAP, "host-in-network-map"},
+	{IMPACT_FLAG_SERVER_ON_PORT, "server-on-port"},
+	{IMPACT_FLAG_OS_VULNERABILITY, "os-vulnerability"},
+	{IMPACT_FLAG_SERVER_VULNERABILITY, "server-vulnerability"},
+	{IMPACT_FLAG_BLOCKED, "blocked"},
+	{IMPACT_FLAG_COMPROMISED, "compromised"},
+}
+
+// ImpactFlags returns the names of the bits set in ImpactFlag, in bit
+// order.  Bits without a known meaning are returned in hex, for
+// example "0x80".
+func (e *EventRecord) ImpactFlags() []string {
+	var flags []string
+	remaining := e.ImpactFlag
+	for _, impactFlag := range impactFlagNames {
+		if e.ImpactFlag&impactFlag.flag != 0 {
+			flags = append(flags, impactFlag.name)
+			remaining &^= impactFlag.flag
+		}
+	}
+	for bit := uint(0); bit < 8; bit++ {
+		if remaining&(1<<bit) != 0 {
+			flags = append(flags, fmt.Sprintf("0x%02x", 1<<bit))
+		}
+	}
+	return flags
+}
+
+// protocolNames are the names of IP protocol numbers, as assigned by
+// IANA.
+var protocolNames = map[uint8]string{
+	1:   "icmp",
+	2:   "igmp",
+	6:   "tcp",
+	17:  "udp",
+	47:  "gre",
+	50:  "esp",
+	51:  "ah",
+	58:  "icmpv6",
+	103: "pim",
+	132: "sctp",
+}
+
+// ProtocolName returns the lower case name of the IP protocol number
+// p, for example "tcp" for 6.  Protocols without a known name are
+// returned as "proto-" followed by the number, for example "proto-41".
+func ProtocolName(p uint8) string {
+	if name, ok := protocolNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("proto-%d", p)
+}
+
+// ProtocolName returns the name of the IP protocol of the event, see
+// ProtocolName.
+func (e *EventRecord) ProtocolName() string {
+	return ProtocolName(e.Protocol)
+}
+
+// ReferenceURLFormat is the format of the URL returned by
+// EventRecord.ReferenceURL, with the signature id as its only verb.
+// It defaults to the Snort rule documentation, and can be changed to
+// point to an internal rule portal.
+var ReferenceURLFormat = "https://www.snort.org/rule_docs/1-%d"
+
+// ReferenceURL returns the URL of the documentation of the rule that
+// generated the event, or an empty string if the event was not
+// generated by a rule, that is the GeneratorId is not 1.
+func (e *EventRecord) ReferenceURL() string {
+	if e.GeneratorId != 1 {
+		return ""
+	}
+	return fmt.Sprintf(ReferenceURLFormat, e.SignatureId)
+}
+
+// Age returns how long ago the event occurred.
+func (e *EventRecord) Age() time.Duration {
+	return time.Since(e.Timestamp())
+}
@@ -0,0 +1,313 @@
+package unified2
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventRecordColumns(t *testing.T) {
+	raw := readFirstEvent(t)
+	event, err := DecodeEventRecord(raw.Type, raw.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := EventColumnNames()
+	columns := event.Columns()
+	if len(names) != len(columns) {
+		t.Fatalf("have %d column names but %d values", len(names), len(columns))
+	}
+
+	row := map[string]interface{}{}
+	for i, name := range names {
+		row[name] = columns[i]
+	}
+
+	if row["event_id"] != event.EventId {
+		t.Fatalf("unexpected event_id: %v", row["event_id"])
+	}
+	if row["src_ip"] != event.IpSource.String() {
+		t.Fatalf("unexpected src_ip: %v", row["src_ip"])
+	}
+	timestamp := row["timestamp"].(time.Time)
+	if timestamp.Unix() != int64(event.EventSecond) {
+		t.Fatalf("unexpected timestamp: %v", timestamp)
+	}
+
+	// Missing addresses are empty, not "<nil>".
+	columns = (&EventRecord{}).Columns()
+	if columns[3] != "" || columns[5] != "" {
+		t.Fatalf("expected empty addresses, got %v and %v", columns[3], columns[5])
+	}
+}
+
+func TestEventRecordZeekConnFields(t *testing.T) {
+	event := &EventRecord{
+		IpSource:      net.IP{10, 0, 0, 1},
+		IpDestination: net.IP{10, 0, 0, 2},
+		SportItype:    1234,
+		DportIcode:    80,
+		Protocol:      6,
+	}
+
+	fields := event.ZeekConnFields()
+	if fields["id.orig_h"] != "10.0.0.1" || fields["id.resp_h"] != "10.0.0.2" {
+		t.Fatalf("unexpected addresses: %v", fields)
+	}
+	if fields["id.orig_p"] != uint16(1234) || fields["id.resp_p"] != uint16(80) {
+		t.Fatalf("unexpected ports: %v", fields)
+	}
+	if fields["proto"] != "tcp" {
+		t.Fatalf("unexpected proto: %v", fields["proto"])
+	}
+
+	event.Protocol = 47
+	if fields := event.ZeekConnFields(); fields["proto"] != "unknown_transport" {
+		t.Fatalf("unexpected proto: %v", fields["proto"])
+	}
+
+	// Addresses not set are empty, as in JSON.
+	event = &EventRecord{Protocol: 58}
+	fields = event.ZeekConnFields()
+	if fields["id.orig_h"] != "" || fields["id.resp_h"] != "" || fields["proto"] != "icmp" {
+		t.Fatalf("unexpected fields: %v", fields)
+	}
+}
+
+func TestEventRecordAttributes(t *testing.T) {
+	event := &EventRecord{
+		EventId:       7,
+		SignatureId:   2000,
+		IpSource:      net.IP{10, 0, 0, 1},
+		IpDestination: net.IP{10, 0, 0, 2},
+		SportItype:    1234,
+		DportIcode:    53,
+		Protocol:      17,
+	}
+
+	attributes := event.Attributes()
+	expected := map[string]interface{}{
+		"network.transport":     "udp",
+		"network.type":          "ipv4",
+		"source.address":        "10.0.0.1",
+		"source.port":           uint16(1234),
+		"destination.address":   "10.0.0.2",
+		"destination.port":      uint16(53),
+		"event.id":              uint32(7),
+		"unified2.signature_id": uint32(2000),
+	}
+	for key, value := range expected {
+		if attributes[key] != value {
+			t.Fatalf("expected %s=%v, got %v", key, value, attributes[key])
+		}
+	}
+
+	event.Protocol = 1
+	attributes = event.Attributes()
+	if _, ok := attributes["source.port"]; ok {
+		t.Fatal("did not expect a source port for ICMP")
+	}
+
+	// Addresses not set are empty, as in JSON.
+	attributes = (&EventRecord{}).Attributes()
+	if attributes["source.address"] != "" || attributes["destination.address"] != "" {
+		t.Fatalf("unexpected addresses: %v", attributes)
+	}
+}
+
+func TestEventRecordEqualIgnoring(t *testing.T) {
+	raw := readFirstEvent(t)
+	a, err := DecodeEventRecord(raw.Type, raw.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := DecodeEventRecord(raw.Type, raw.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !a.Equal(b) {
+		t.Fatal("expected events to be equal")
+	}
+
+	b.SensorId++
+	b.EventMicrosecond++
+	if a.Equal(b) {
+		t.Fatal("did not expect events to be equal")
+	}
+	if !a.EqualIgnoring(b, "SensorId", "EventMicrosecond") {
+		t.Fatal("expected events to be equal ignoring SensorId and EventMicrosecond")
+	}
+	if a.EqualIgnoring(b, "SensorId") {
+		t.Fatal("did not expect events to be equal ignoring only SensorId")
+	}
+
+	b.IpSource = net.IP{1, 2, 3, 4}
+	if a.EqualIgnoring(b, "SensorId", "EventMicrosecond") {
+		t.Fatal("did not expect events with different addresses to be equal")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for unknown field")
+		}
+	}()
+	a.EqualIgnoring(b, "NoSuchField")
+}
+
+func TestEventRecordImpactFlags(t *testing.T) {
+	event := &EventRecord{ImpactFlag: IMPACT_FLAG_MONITORED_NETWORK |
+		IMPACT_FLAG_BLOCKED | 0x80}
+	flags := event.ImpactFlags()
+	expected := []string{"monitored-network", "blocked", "0x80"}
+	if !reflect.DeepEqual(flags, expected) {
+		t.Fatalf("expected %v, got %v", expected, flags)
+	}
+
+	event.ImpactFlag = 0
+	if len(event.ImpactFlags()) != 0 {
+		t.Fatalf("expected no flags, got %v", event.ImpactFlags())
+	}
+}
+
+func TestEventRecordReferenceURL(t *testing.T) {
+	event := &EventRecord{GeneratorId: 1, SignatureId: 2019418}
+	if url := event.ReferenceURL(); url != "https://www.snort.org/rule_docs/1-2019418" {
+		t.Fatalf("unexpected url: %s", url)
+	}
+
+	event.GeneratorId = 119
+	if url := event.ReferenceURL(); url != "" {
+		t.Fatalf("expected no url, got %s", url)
+	}
+}
+
+func TestEventRecordICMP(t *testing.T) {
+	event := EventRecord{Protocol: 1, SportItype: 8, DportIcode: 0}
+	typ, code, ok := event.ICMP()
+	if !ok || typ != 8 || code != 0 {
+		t.Fatalf("unexpected ICMP type %d, code %d, ok %v", typ, code, ok)
+	}
+
+	event = EventRecord{Protocol: 58, SportItype: 1, DportIcode: 4}
+	typ, code, ok = event.ICMP()
+	if !ok || typ != 1 || code != 4 {
+		t.Fatalf("unexpected ICMPv6 type %d, code %d, ok %v", typ, code, ok)
+	}
+
+	event = EventRecord{Protocol: 6, SportItype: 80, DportIcode: 2651}
+	if _, _, ok := event.ICMP(); ok {
+		t.Fatalf("expected ok to be false for TCP")
+	}
+}
+
+func TestEventRecordCopy(t *testing.T) {
+	raw := readFirstEvent(t)
+	event, err := DecodeEventRecord(raw.Type, raw.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventCopy := event.Copy()
+	event.IpSource[0] = 0
+
+	if eventCopy.IpSource.String() != "207.25.71.28" {
+		t.Fatalf("unexpected copy source %s", eventCopy.IpSource)
+	}
+	if !eventCopy.EqualIgnoring(event, "IpSource") {
+		t.Fatalf("expected copy to equal the event")
+	}
+}
+
+func TestEventRecordRevision(t *testing.T) {
+	event := EventRecord{GeneratorId: 1, SignatureRevision: 4}
+	if rev, ok := event.Revision(); !ok || rev != 4 {
+		t.Fatalf("expected revision 4, got %d, %v", rev, ok)
+	}
+
+	event = EventRecord{GeneratorId: 120, SignatureRevision: 1}
+	if _, ok := event.Revision(); ok {
+		t.Fatalf("expected revision not to be meaningful for gid 120")
+	}
+}
+
+func TestEventRecordIPs(t *testing.T) {
+	raw := readFirstEvent(t)
+	event, err := DecodeEventRecord(raw.Type, raw.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.SourceIP().String() != "207.25.71.28" ||
+		event.DestinationIP().String() != "10.20.11.123" {
+		t.Fatalf("unexpected addresses %s -> %s", event.SourceIP(),
+			event.DestinationIP())
+	}
+	if event.IsIPv6() {
+		t.Fatalf("expected an IPv4 event")
+	}
+
+	event = &EventRecord{}
+	if event.SourceIP() != nil || event.DestinationIP() != nil || event.IsIPv6() {
+		t.Fatalf("expected no addresses for an empty event")
+	}
+}
+
+func TestEventRecordString(t *testing.T) {
+	raw := readFirstEvent(t)
+	event, err := DecodeEventRecord(raw.Type, raw.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "event 89 [120:3:1] 207.25.71.28:80 -> 10.20.11.123:2651 proto 6 at 2000-07-28T15:40:04.267362Z"
+	if event.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, event.String())
+	}
+
+	// IPv6 addresses are bracketed when followed by a port.
+	event.IpSource = net.ParseIP("2001:db8::1")
+	event.IpDestination = net.ParseIP("2001:db8::2")
+	event.Protocol = 17
+	expected = "event 89 [120:3:1] [2001:db8::1]:80 -> [2001:db8::2]:2651 proto 17 at 2000-07-28T15:40:04.267362Z"
+	if event.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, event.String())
+	}
+
+	// No ports for other protocols.
+	event.Protocol = 58
+	expected = "event 89 [120:3:1] 2001:db8::1 -> 2001:db8::2 proto 58 at 2000-07-28T15:40:04.267362Z"
+	if event.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, event.String())
+	}
+
+	// Missing addresses are left empty, not "<nil>".
+	event.IpSource = nil
+	event.IpDestination = nil
+	expected = "event 89 [120:3:1]  ->  proto 58 at 2000-07-28T15:40:04.267362Z"
+	if event.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, event.String())
+	}
+}
+
+func TestProtocolName(t *testing.T) {
+	for p, expected := range map[uint8]string{
+		1:   "icmp",
+		6:   "tcp",
+		17:  "udp",
+		41:  "proto-41",
+		47:  "gre",
+		50:  "esp",
+		58:  "icmpv6",
+		255: "proto-255",
+	} {
+		if name := ProtocolName(p); name != expected {
+			t.Fatalf("protocol %d: expected %q, got %q", p, expected, name)
+		}
+	}
+
+	event := &EventRecord{Protocol: 6}
+	if event.ProtocolName() != "tcp" {
+		t.Fatalf("unexpected protocol name %q", event.ProtocolName())
+	}
+}
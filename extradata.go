@@ -0,0 +1,203 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// Extra data types, the Type field of an ExtraDataRecord.  These are
+// the EVENT_INFO types defined by Snort.
+const (
+	EXTRA_DATA_TYPE_XFF_IPV4        = 1
+	EXTRA_DATA_TYPE_XFF_IPV6        = 2
+	EXTRA_DATA_TYPE_REVIEWED_BY     = 3
+	EXTRA_DATA_TYPE_GZIP_DATA       = 4
+	EXTRA_DATA_TYPE_SMTP_FILENAME   = 5
+	EXTRA_DATA_TYPE_SMTP_MAILFROM   = 6
+	EXTRA_DATA_TYPE_SMTP_RCPTTO     = 7
+	EXTRA_DATA_TYPE_SMTP_EMAIL_HDRS = 8
+	EXTRA_DATA_TYPE_HTTP_URI        = 9
+	EXTRA_DATA_TYPE_HTTP_HOSTNAME   = 10
+	EXTRA_DATA_TYPE_IPV6_SRC        = 11
+	EXTRA_DATA_TYPE_IPV6_DST        = 12
+	EXTRA_DATA_TYPE_JSNORM_DATA     = 13
+)
+
+// An ExtraDataDecoder decodes the data of an extra data record.
+type ExtraDataDecoder func(data []byte) (interface{}, error)
+
+var extraDataDecoders = struct {
+	sync.RWMutex
+	decoders map[uint32]ExtraDataDecoder
+}{decoders: make(map[uint32]ExtraDataDecoder)}
+
+// RegisterExtraDataDecoder registers a decoder used by DecodeData for
+// extra data records of the provided type, the Type field of the
+// record.
+//
+// Registered decoders take precedence over the built-in decoding, so
+// this can be used to decode vendor specific types as well as to
+// replace the decoding of known types.  Registering a nil decoder
+// removes a previously registered decoder.
+func RegisterExtraDataDecoder(extraDataType uint32, decoder func([]byte) (interface{}, error)) {
+	extraDataDecoders.Lock()
+	defer extraDataDecoders.Unlock()
+	if decoder == nil {
+		delete(extraDataDecoders.decoders, extraDataType)
+	} else {
+		extraDataDecoders.decoders[extraDataType] = decoder
+	}
+}
+
+func decodeIPv4(data []byte) (interface{}, error) {
+	if len(data) != net.IPv4len {
+		return nil, DecodingError
+	}
+	return net.IP(data), nil
+}
+
+func decodeIPv6(data []byte) (interface{}, error) {
+	if len(data) != net.IPv6len {
+		return nil, DecodingError
+	}
+	return net.IP(data), nil
+}
+
+func decodeString(data []byte) (interface{}, error) {
+	return string(data), nil
+}
+
+// The built-in extra data decoders.
+var builtinExtraDataDecoders = map[uint32]ExtraDataDecoder{
+	EXTRA_DATA_TYPE_XFF_IPV4:        decodeIPv4,
+	EXTRA_DATA_TYPE_XFF_IPV6:        decodeIPv6,
+	EXTRA_DATA_TYPE_REVIEWED_BY:     decodeString,
+	EXTRA_DATA_TYPE_SMTP_FILENAME:   decodeString,
+	EXTRA_DATA_TYPE_SMTP_MAILFROM:   decodeString,
+	EXTRA_DATA_TYPE_SMTP_RCPTTO:     decodeString,
+	EXTRA_DATA_TYPE_SMTP_EMAIL_HDRS: decodeString,
+	EXTRA_DATA_TYPE_HTTP_URI:        decodeString,
+	EXTRA_DATA_TYPE_HTTP_HOSTNAME:   decodeString,
+	EXTRA_DATA_TYPE_IPV6_SRC:        decodeIPv6,
+	EXTRA_DATA_TYPE_IPV6_DST:        decodeIPv6,
+	EXTRA_DATA_TYPE_JSNORM_DATA:     decodeString,
+}
+
+// DecodeData decodes the data of the extra data record based on its
+// Type.
+//
+// A decoder registered with RegisterExtraDataDecoder is used first,
+// otherwise the data is decoded as by DecodedValue.
+func (e *ExtraDataRecord) DecodeData() (interface{}, error) {
+	extraDataDecoders.RLock()
+	decoder, ok := extraDataDecoders.decoders[e.Type]
+	extraDataDecoders.RUnlock()
+	if ok {
+		return decoder(e.Data)
+	}
+	return e.DecodedValue()
+}
+
+// DecodedValue decodes the data of the extra data record based on its
+// Type, ignoring any decoders registered with RegisterExtraDataDecoder.
+//
+// The IP address types (EXTRA_DATA_TYPE_XFF_IPV4,
+// EXTRA_DATA_TYPE_XFF_IPV6, EXTRA_DATA_TYPE_IPV6_SRC and
+// EXTRA_DATA_TYPE_IPV6_DST) are decoded to a net.IP, and the text types
+// to a string.  The data of other types, such as
+// EXTRA_DATA_TYPE_GZIP_DATA and unknown types, is returned as is with
+// no error.  A DecodingError is returned if the data of an IP address
+// type is not of the address length.
+func (e *ExtraDataRecord) DecodedValue() (interface{}, error) {
+	decoder, ok := builtinExtraDataDecoders[e.Type]
+	if !ok {
+		return e.Data, nil
+	}
+	return decoder(e.Data)
+}
+
+// Copy returns a copy of the record that does not share memory with
+// the record or the data it was decoded from.
+func (e *ExtraDataRecord) Copy() *ExtraDataRecord {
+	extra := *e
+	extra.Data = copyBytes(e.Data)
+	extra.Trailer = copyBytes(e.Trailer)
+	return &extra
+}
+
+// String returns a one line summary of the extra data record, with its
+// event, type and the length of its data.
+func (e *ExtraDataRecord) String() string {
+	return fmt.Sprintf("extra data event %d type %d length %d",
+		e.EventId, e.Type, len(e.Data))
+}
+
+// Timestamp returns the time of the event the extra data belongs to as
+// a time.Time in UTC.  Extra data records only carry the second of the
+// event.
+func (e *ExtraDataRecord) Timestamp() time.Time {
+	return time.Unix(int64(e.EventSecond), 0).UTC()
+}
+
+// MergeExtraData merges consecutive extra data records for the same
+// event and of the same Type and DataType into a single record, for
+// producers that split large extra data payloads over several
+// records.
+//
+// This is a heuristic: two separate values of the same type logged
+// back to back for an event will also be merged.  The merged record
+// is a new record with the data concatenated and the EventLength and
+// DataLength updated, the records passed in are not modified.
+func MergeExtraData(records []*ExtraDataRecord) []*ExtraDataRecord {
+	merged := make([]*ExtraDataRecord, 0, len(records))
+
+	for _, record := range records {
+		if len(merged) > 0 {
+			last := merged[len(merged)-1]
+			if last.SensorId == record.SensorId &&
+				last.EventId == record.EventId &&
+				last.Type == record.Type &&
+				last.DataType == record.DataType {
+				data := make([]byte, 0, len(last.Data)+len(record.Data))
+				data = append(append(data, last.Data...), record.Data...)
+				combined := *last
+				combined.Data = data
+				combined.DataLength = uint32(len(data)) + extraDataLengthOverhead
+				combined.EventLength = EXTRA_DATA_RECORD_HDR_LEN + uint32(len(data))
+				merged[len(merged)-1] = &combined
+				continue
+			}
+		}
+		merged = append(merged, record)
+	}
+
+	return merged
+}
@@ -0,0 +1,92 @@
+package unified2
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestExtraDataDecodeData(t *testing.T) {
+	uri := &ExtraDataRecord{Type: EXTRA_DATA_TYPE_HTTP_URI, Data: []byte("/")}
+	value, err := uri.DecodeData()
+	if err != nil || value != "/" {
+		t.Fatalf("unexpected value: %v, err=%v", value, err)
+	}
+
+	xff := &ExtraDataRecord{Type: EXTRA_DATA_TYPE_XFF_IPV4, Data: []byte{10, 0, 0, 1}}
+	value, err = xff.DecodeData()
+	if err != nil || !value.(net.IP).Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Fatalf("unexpected value: %v, err=%v", value, err)
+	}
+
+	xff.Data = []byte{10, 0, 0}
+	if _, err := xff.DecodeData(); !errors.Is(err, DecodingError) {
+		t.Fatalf("expected DecodingError, got %v", err)
+	}
+
+	unknown := &ExtraDataRecord{Type: 1000, Data: []byte{1, 2}}
+	value, err = unknown.DecodeData()
+	if err != nil || len(value.([]byte)) != 2 {
+		t.Fatalf("unexpected value: %v, err=%v", value, err)
+	}
+
+	// A registered decoder takes precedence.
+	RegisterExtraDataDecoder(EXTRA_DATA_TYPE_HTTP_URI, func(data []byte) (interface{}, error) {
+		return len(data), nil
+	})
+	defer RegisterExtraDataDecoder(EXTRA_DATA_TYPE_HTTP_URI, nil)
+	value, err = uri.DecodeData()
+	if err != nil || value != 1 {
+		t.Fatalf("unexpected value: %v, err=%v", value, err)
+	}
+
+	// But is not used by DecodedValue.
+	value, err = uri.DecodedValue()
+	if err != nil || value != "/" {
+		t.Fatalf("unexpected value: %v, err=%v", value, err)
+	}
+}
+
+func TestMergeExtraData(t *testing.T) {
+	records := []*ExtraDataRecord{
+		{EventId: 1, Type: EXTRA_DATA_TYPE_HTTP_URI, DataType: 1, Data: []byte("/long")},
+		{EventId: 1, Type: EXTRA_DATA_TYPE_HTTP_URI, DataType: 1, Data: []byte("/uri")},
+		{EventId: 1, Type: EXTRA_DATA_TYPE_HTTP_HOSTNAME, DataType: 1, Data: []byte("host")},
+		{EventId: 2, Type: EXTRA_DATA_TYPE_HTTP_HOSTNAME, DataType: 1, Data: []byte("other")},
+	}
+
+	merged := MergeExtraData(records)
+	if len(merged) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(merged))
+	}
+	if string(merged[0].Data) != "/long/uri" {
+		t.Fatalf("unexpected merged data: %s", merged[0].Data)
+	}
+	if merged[0].DataLength != 9+extraDataLengthOverhead {
+		t.Fatalf("unexpected DataLength: %d", merged[0].DataLength)
+	}
+	if string(records[0].Data) != "/long" {
+		t.Fatal("input record should not have been modified")
+	}
+	if merged[1] != records[2] || merged[2] != records[3] {
+		t.Fatal("expected unmerged records to be passed through")
+	}
+}
+
+func TestExtraDataRecordCopy(t *testing.T) {
+	extra := &ExtraDataRecord{Type: EXTRA_DATA_TYPE_HTTP_URI, Data: []byte("/index.html")}
+	extraCopy := extra.Copy()
+	extra.Data[0] = 'X'
+
+	if string(extraCopy.Data) != "/index.html" || extraCopy.Trailer != nil {
+		t.Fatalf("unexpected copy %+v", extraCopy)
+	}
+}
+
+func TestExtraDataRecordString(t *testing.T) {
+	extra := &ExtraDataRecord{EventId: 89, Type: EXTRA_DATA_TYPE_HTTP_URI, Data: []byte("/index.html")}
+	expected := "extra data event 89 type 9 length 11"
+	if extra.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, extra.String())
+	}
+}
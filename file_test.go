@@ -0,0 +1,111 @@
+package unified2
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readFileRecords reads all the records of filename with OpenFile.
+func readFileRecords(t *testing.T, filename string) []interface{} {
+	reader, err := OpenFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	var records []interface{}
+	for {
+		record, err := reader.ReadRecord()
+		if err == io.EOF {
+			return records
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+}
+
+func TestOpenFileGzip(t *testing.T) {
+	plain := readFileRecords(t, "test/multi-record-event.log")
+	if len(plain) != 17 {
+		t.Fatalf("expected 17 records, got %d", len(plain))
+	}
+
+	compressed := readFileRecords(t, "test/multi-record-event.log.gz")
+	if !reflect.DeepEqual(plain, compressed) {
+		t.Fatal("records of compressed file differ")
+	}
+
+	// A compressed file without the .gz suffix is detected by its
+	// magic bytes.
+	buf, err := ioutil.ReadFile("test/multi-record-event.log.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "unified2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "unified2.log.1680000000")
+	if err := ioutil.WriteFile(filename, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(plain, readFileRecords(t, filename)) {
+		t.Fatal("records of compressed file without suffix differ")
+	}
+}
+
+// A FileReader can be aggregated, ending with io.EOF.
+func TestOpenFileAggregate(t *testing.T) {
+	reader, err := OpenFile("test/multi-record-event.log.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	aggregate := NewAggregateReader(reader.Source())
+	event, err := aggregate.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Event == nil || len(event.Packets) != 15 || len(event.ExtraData) != 1 {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if _, err := aggregate.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+
+	// Closing the aggregate closes the file.
+	if err := aggregate.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected os.ErrClosed, got %v", err)
+	}
+}
+
+// Closing a compressed file closes the gzip reader and the file.
+func TestOpenFileGzipClose(t *testing.T) {
+	reader, err := OpenFile("test/multi-record-event.log.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if reader.gzip == nil {
+		t.Fatal("expected the file to be decompressed")
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.file.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Fatalf("expected the file to be closed, got %v", err)
+	}
+}
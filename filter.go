@@ -0,0 +1,145 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"io"
+)
+
+// FilterReader reads records from a file, returning only the records
+// accepted by a filter function.
+//
+// FilterReaders should be created with NewFilterReader().
+type FilterReader struct {
+	// Filter is called with each record after it has been decoded,
+	// only records it returns true for are returned.
+	Filter func(*RecordContainer) bool
+
+	// TypeFilter, if set, is called with the type of each record
+	// before the record is read.  Records it returns false for are
+	// skipped over without being read or decoded, and are not passed
+	// to Filter.
+	TypeFilter func(recordType uint32) bool
+
+	// FollowEvents enables returning the packet and extra data records
+	// following an event accepted by Filter that belong to the event,
+	// without passing them to Filter.  TypeFilter still applies to
+	// them.
+	FollowEvents bool
+
+	file io.ReadSeeker
+
+	// The event accepted by Filter whose records are being followed.
+	following bool
+	key       EventKey
+}
+
+// NewFilterReader creates a new FilterReader reading from the current
+// position of file and returning the records filter returns true for.
+func NewFilterReader(file io.ReadSeeker, filter func(*RecordContainer) bool) *FilterReader {
+	return &FilterReader{Filter: filter, file: file}
+}
+
+// ReadRecord reads records until one is accepted, and returns it.
+//
+// The errors returned are those of ReadRecord.  As by ReadRecord, on
+// an incomplete record the file offset is left at the start of the
+// record, so ReadRecord can be called again once more data is
+// available.
+func (r *FilterReader) ReadRecord() (*RecordContainer, error) {
+	for {
+		if r.TypeFilter != nil {
+			recordType, err := PeekType(r.file)
+			if err != nil {
+				return nil, err
+			}
+			if !r.TypeFilter(recordType) {
+				if isEventType(recordType) {
+					r.following = false
+				}
+				if _, _, err := SkipRecord(r.file); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+
+		container, err := readRecord(r.file)
+		if err != nil {
+			return nil, err
+		}
+
+		if event, ok := container.Record.(*EventRecord); ok {
+			r.following = false
+			if r.Filter(container) {
+				r.following = r.FollowEvents
+				r.key = event.Key()
+				return container, nil
+			}
+			continue
+		}
+
+		if r.following && recordKey(container.Record) == r.key {
+			return container, nil
+		}
+		r.following = false
+		if r.Filter(container) {
+			return container, nil
+		}
+	}
+}
+
+// Next returns the record of the next accepted record, as ReadRecord,
+// so a FilterReader can be used as a RecordSource.
+func (r *FilterReader) Next() (interface{}, error) {
+	container, err := r.ReadRecord()
+	if err != nil {
+		return nil, err
+	}
+	return container.Record, nil
+}
+
+// Close closes the file, if it is an io.Closer.
+func (r *FilterReader) Close() error {
+	if closer, ok := r.file.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// recordKey returns the EventKey of the event a record belongs to.
+func recordKey(record interface{}) EventKey {
+	switch record := record.(type) {
+	case *EventRecord:
+		return record.Key()
+	case *PacketRecord:
+		return EventKey{record.SensorId, record.EventId}
+	case *ExtraDataRecord:
+		return EventKey{record.SensorId, record.EventId}
+	}
+	return EventKey{}
+}
@@ -0,0 +1,65 @@
+package unified2
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// filterCount returns the number of records returned by reader.
+func filterCount(t *testing.T, reader *FilterReader) int {
+	count := 0
+	for {
+		_, err := reader.ReadRecord()
+		if atEOF(err) {
+			return count
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		count++
+	}
+}
+
+func TestFilterReader(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := func(sid uint32) func(*RecordContainer) bool {
+		return func(c *RecordContainer) bool {
+			event, ok := c.Record.(*EventRecord)
+			return ok && event.SignatureId == sid
+		}
+	}
+
+	reader := NewFilterReader(bytes.NewReader(buf), signature(3))
+	if count := filterCount(t, reader); count != 1 {
+		t.Fatalf("expected 1 record, got %d", count)
+	}
+
+	reader = NewFilterReader(bytes.NewReader(buf), signature(3))
+	reader.FollowEvents = true
+	if count := filterCount(t, reader); count != 17 {
+		t.Fatalf("expected 17 records, got %d", count)
+	}
+
+	reader = NewFilterReader(bytes.NewReader(buf), signature(4))
+	reader.FollowEvents = true
+	if count := filterCount(t, reader); count != 0 {
+		t.Fatalf("expected 0 records, got %d", count)
+	}
+
+	// Only packets are read and decoded.
+	decoded := 0
+	reader = NewFilterReader(bytes.NewReader(buf), func(c *RecordContainer) bool {
+		decoded++
+		return true
+	})
+	reader.TypeFilter = func(recordType uint32) bool {
+		return recordType == UNIFIED2_PACKET
+	}
+	if count := filterCount(t, reader); count != 15 || decoded != 15 {
+		t.Fatalf("expected 15 records, got %d with %d decoded", count, decoded)
+	}
+}
@@ -0,0 +1,166 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// FollowingReader reads records from a file that is still being
+// written, such as the current unified2 file of Snort or Suricata.
+// Instead of returning at the end of the file, it waits for more
+// records to be written, returning a live stream of records.
+//
+// FollowingReaders should be created with NewFollowingReader().
+type FollowingReader struct {
+	// PollInterval is how often the file is checked for more data at
+	// the end of the file.  If 0 the package PollInterval is used.
+	PollInterval time.Duration
+
+	// Notify enables file change notification, inotify on Linux, to
+	// pick up new records as soon as they are written.  The file is
+	// still checked every PollInterval, so on platforms or filesystems
+	// where notification is not supported, or events are not
+	// delivered, as on network filesystems, following falls back to
+	// polling.
+	Notify bool
+
+	// ReadTimeout, if greater than 0, limits how long Next and
+	// NextContext wait for a record to be written, returning
+	// ErrReadTimeout if none is available in time, so callers may do
+	// other work between waits.  Reading may be retried after a
+	// timeout.
+	ReadTimeout time.Duration
+
+	file   *os.File
+	reader *Reader
+
+	watcher      watcher
+	notifyFailed bool
+}
+
+// watcher waits for a file to be modified.
+type watcher interface {
+	// wait returns when the file is modified or timeout expires, or
+	// returns ctx.Err() if ctx is cancelled first.
+	wait(ctx context.Context, timeout time.Duration) error
+	close() error
+}
+
+// NewFollowingReader opens filename for following, starting at offset,
+// which should be the start of a record.
+func NewFollowingReader(filename string, offset int64) (*FollowingReader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > 0 {
+		if _, err := file.Seek(offset, 0); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	reader := NewReader(file)
+	reader.offset = offset
+
+	return &FollowingReader{file: file, reader: reader}, nil
+}
+
+// Next returns the next record, waiting for it to be written if the
+// end of the file has been reached.  It only returns on a record or an
+// error other than reaching the end of the file, including
+// ErrReadTimeout if a ReadTimeout is set.
+func (r *FollowingReader) Next() (*RecordContainer, error) {
+	return r.NextContext(context.Background())
+}
+
+// NextContext returns the next record as Next, but stops waiting and
+// returns ctx.Err() if ctx is cancelled.
+func (r *FollowingReader) NextContext(ctx context.Context) (*RecordContainer, error) {
+	waitCtx, cancel := timeoutContext(ctx, r.ReadTimeout)
+	defer cancel()
+
+	for {
+		container, err := r.reader.Next()
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return container, err
+		}
+
+		if err := r.wait(waitCtx); err != nil {
+			if err == context.DeadlineExceeded && ctx.Err() == nil {
+				return nil, ErrReadTimeout
+			}
+			return nil, err
+		}
+	}
+}
+
+// wait waits for the file to be modified, or for the poll interval.
+func (r *FollowingReader) wait(ctx context.Context) error {
+	interval := r.PollInterval
+	if interval == 0 {
+		interval = PollInterval
+	}
+
+	if r.Notify && r.watcher == nil && !r.notifyFailed {
+		watcher, err := newWatcher(r.file.Name())
+		if err != nil {
+			r.notifyFailed = true
+		} else {
+			r.watcher = watcher
+		}
+	}
+	if r.watcher != nil {
+		return r.watcher.wait(ctx, interval)
+	}
+
+	return sleepContext(ctx, interval)
+}
+
+// Offset returns the offset in the file of the next record.
+func (r *FollowingReader) Offset() int64 {
+	return r.reader.Offset()
+}
+
+// Stats returns the counters of the records read so far.  It may be
+// called while another goroutine is reading.
+func (r *FollowingReader) Stats() ReaderStats {
+	return r.reader.Stats()
+}
+
+// Close closes the file, and stops any file change notification.
+func (r *FollowingReader) Close() error {
+	if r.watcher != nil {
+		r.watcher.close()
+	}
+	return r.file.Close()
+}
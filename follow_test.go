@@ -0,0 +1,186 @@
+package unified2
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFollowingReader(t *testing.T) {
+	data, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := tmpdir + "/unified2.log"
+
+	// Start with the first record and part of the second.
+	if err := ioutil.WriteFile(filename, data[:100], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewFollowingReader(filename, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	reader.PollInterval = time.Millisecond
+
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := reader.NextContext(timeout); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// The ReadTimeout is reported distinctly from the context.
+	reader.ReadTimeout = 20 * time.Millisecond
+	if _, err := reader.Next(); err != ErrReadTimeout {
+		t.Fatalf("expected ErrReadTimeout, got %v", err)
+	}
+	reader.ReadTimeout = time.Minute
+
+	// The rest of the file is written while waiting.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+		file.Write(data[100:])
+	}()
+
+	for i := 1; i < 17; i++ {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reader.Offset() != int64(len(data)) {
+		t.Fatalf("expected offset %d, got %d", len(data), reader.Offset())
+	}
+
+	// Following from an offset starts at that record.
+	resumed, err := NewFollowingReader(filename, 68)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	container, err := resumed.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.Type != UNIFIED2_EXTRA_DATA || resumed.Offset() != 18678 {
+		t.Fatalf("unexpected record type %d at offset %d", container.Type,
+			resumed.Offset())
+	}
+}
+
+// With notification new records are read without waiting for the poll
+// interval.
+func TestFollowingReaderNotify(t *testing.T) {
+	data, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := tmpdir + "/unified2.log"
+
+	if err := ioutil.WriteFile(filename, data[:68], 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWatcher(filename)
+	if err != nil {
+		t.Skip("file change notification not supported:", err)
+	}
+	w.close()
+
+	reader, err := NewFollowingReader(filename, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	reader.PollInterval = time.Minute
+	reader.Notify = true
+
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+		file.Write(data[68:18678])
+	}()
+
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	container, err := reader.NextContext(timeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.Type != UNIFIED2_EXTRA_DATA {
+		t.Fatalf("unexpected record type %d", container.Type)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected notification of the write, took %s", elapsed)
+	}
+
+	// Cancelling the context stops waiting for a notification.
+	timeout, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := reader.NextContext(timeout); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+// A wait with the context already cancelled returns at once rather
+// than after the poll interval.
+func TestWatcherCancelled(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := tmpdir + "/unified2.log"
+	if err := ioutil.WriteFile(filename, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWatcher(filename)
+	if err != nil {
+		t.Skip("file change notification not supported:", err)
+	}
+	defer w.close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := w.wait(ctx, time.Minute); err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("cancelled waits took %s", elapsed)
+	}
+}
@@ -0,0 +1,221 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// EventRefs are the offsets of the records of an event in a file.
+type EventRefs struct {
+	// Event is the offset of the event record, or -1 if only packet
+	// or extra data records of the event were found.
+	Event int64
+
+	// Packets and ExtraData are the offsets of the packet and extra
+	// data records of the event, in file order.
+	Packets   []int64
+	ExtraData []int64
+}
+
+// IndexEntry is the position, type and time of a record in a file.
+type IndexEntry struct {
+	Offset int64
+	Type   uint32
+
+	// Time is the time of the event of the record.  For packet and
+	// extra data records following their event this is the time of the
+	// event, otherwise the EventSecond of the record.
+	Time time.Time
+}
+
+// Index maps events to the offsets of their records in a file, for
+// example to jump to the packets of an event, and records the offset,
+// type and time of every record for random access by record number or
+// time.
+//
+// Indexes should be created with BuildIndex().
+type Index struct {
+	refs    map[EventKey]*EventRefs
+	entries []IndexEntry
+}
+
+// BuildIndex scans file from the current position to the end and
+// returns an Index of the records found.
+//
+// Only the record headers and the SensorId, EventId and time of each
+// record are read, the rest of the records are skipped over by
+// seeking.  If the file ends with an incomplete record
+// ErrBufferTooSmall is returned along with the index of the complete
+// records, leaving the file positioned at the start of the incomplete
+// record.
+func BuildIndex(file io.ReadSeeker) (*Index, error) {
+	index := &Index{refs: make(map[EventKey]*EventRefs)}
+
+	// The key and time of the last event, for the time of the packet
+	// and extra data records following it.
+	var lastKey EventKey
+	var lastTime time.Time
+
+	for {
+		header, offset, err := SkipRecord(file)
+		if err != nil {
+			if atEOF(err) {
+				return index, nil
+			}
+			return index, err
+		}
+
+		key, recordTime, err := readEventKey(file, header, offset)
+		if err != nil {
+			return index, err
+		}
+		if isEventType(header.Type) {
+			lastKey, lastTime = key, recordTime
+		} else if key == lastKey {
+			recordTime = lastTime
+		}
+		index.entries = append(index.entries, IndexEntry{offset, header.Type, recordTime})
+
+		refs := index.refs[key]
+		if refs == nil {
+			refs = &EventRefs{Event: -1}
+			index.refs[key] = refs
+		}
+		switch header.Type {
+		case UNIFIED2_PACKET:
+			refs.Packets = append(refs.Packets, offset)
+		case UNIFIED2_EXTRA_DATA:
+			refs.ExtraData = append(refs.ExtraData, offset)
+		default:
+			refs.Event = offset
+		}
+	}
+}
+
+// readEventKey reads the SensorId, EventId and time of the record with
+// the provided header at offset, leaving the file positioned at the end
+// of the record.  The time of packet and extra data records is their
+// EventSecond.
+func readEventKey(file io.ReadSeeker, header RawHeader, offset int64) (EventKey, time.Time, error) {
+	// The offset of the SensorId, EventId and EventSecond, followed
+	// by EventMicrosecond for events.
+	idOffset, n := 0, 16
+	switch header.Type {
+	case UNIFIED2_PACKET:
+		n = 12
+	case UNIFIED2_EXTRA_DATA:
+		// Skip the EventType and EventLength.
+		idOffset, n = 8, 20
+	}
+	if int(header.Len) < n {
+		return EventKey{}, time.Time{}, &DecodeError{header.Type, offset,
+			"record too short for event id"}
+	}
+
+	data, err := readPrefix(file, header, offset, n)
+	if err != nil {
+		return EventKey{}, time.Time{}, err
+	}
+
+	key := EventKey{
+		SensorId: binary.BigEndian.Uint32(data[idOffset:]),
+		EventId:  binary.BigEndian.Uint32(data[idOffset+4:]),
+	}
+	var micros uint32
+	if isEventType(header.Type) {
+		micros = binary.BigEndian.Uint32(data[12:])
+	}
+	recordTime := time.Unix(int64(binary.BigEndian.Uint32(data[idOffset+8:])),
+		int64(micros)*int64(time.Microsecond)).UTC()
+	return key, recordTime, nil
+}
+
+// Offsets returns the offsets of the records of the event with the
+// provided SensorId and EventId, and false if no records of the event
+// were found.
+func (i *Index) Offsets(sensorId, eventId uint32) (EventRefs, bool) {
+	refs, ok := i.refs[EventKey{sensorId, eventId}]
+	if !ok {
+		return EventRefs{}, false
+	}
+	return *refs, true
+}
+
+// Len returns the number of events in the index.
+func (i *Index) Len() int {
+	return len(i.refs)
+}
+
+// NumRecords returns the number of records in the index.
+func (i *Index) NumRecords() int {
+	return len(i.entries)
+}
+
+// Entry returns the index entry of record n, counting from 0.  An
+// error is returned if there is no record n.
+func (i *Index) Entry(n int) (IndexEntry, error) {
+	if err := i.checkRecord(n); err != nil {
+		return IndexEntry{}, err
+	}
+	return i.entries[n], nil
+}
+
+// ReadAt reads and returns record n, counting from 0, from file, which
+// must be the file indexed.  The file is left positioned after the
+// record, so reading may continue sequentially from there.
+func (i *Index) ReadAt(file io.ReadSeeker, n int) (*RecordContainer, error) {
+	if err := i.checkRecord(n); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(i.entries[n].Offset, 0); err != nil {
+		return nil, err
+	}
+	return readRecord(file)
+}
+
+// checkRecord returns an error if there is no record n.
+func (i *Index) checkRecord(n int) error {
+	if n < 0 || n >= len(i.entries) {
+		return fmt.Errorf("record %d out of range of %d records", n,
+			len(i.entries))
+	}
+	return nil
+}
+
+// Search returns the number of the first record with a Time at or
+// after t, or NumRecords if there is none.  The records are expected to
+// be ordered by time, as written by a single sensor.
+func (i *Index) Search(t time.Time) int {
+	return sort.Search(len(i.entries), func(n int) bool {
+		return !i.entries[n].Time.Before(t)
+	})
+}
@@ -0,0 +1,148 @@
+package unified2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildIndex(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := BuildIndex(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Len() != 1 {
+		t.Fatalf("expected 1 event, got %d", index.Len())
+	}
+
+	raw := readFirstEvent(t)
+	event, err := DecodeEventRecord(raw.Type, raw.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs, ok := index.Offsets(event.SensorId, event.EventId)
+	if !ok {
+		t.Fatal("event not found")
+	}
+	if refs.Event != 0 || len(refs.ExtraData) != 1 || refs.ExtraData[0] != 68 ||
+		len(refs.Packets) != 15 || refs.Packets[0] != 18678 {
+		t.Fatalf("unexpected refs %+v", refs)
+	}
+
+	// Each offset must be the start of a record of the event.
+	file := bytes.NewReader(buf)
+	for _, offset := range refs.Packets {
+		file.Seek(offset, 0)
+		record, err := ReadRecord(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet, ok := record.(*PacketRecord)
+		if !ok || packet.EventId != event.EventId {
+			t.Fatalf("unexpected record at %d: %v", offset, record)
+		}
+	}
+
+	if _, ok := index.Offsets(event.SensorId, event.EventId+1); ok {
+		t.Fatal("unexpected refs for unknown event")
+	}
+}
+
+func TestBuildIndexIncomplete(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := bytes.NewReader(buf[:18800])
+	index, err := BuildIndex(file)
+	e := &ErrBufferTooSmall{}
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrBufferTooSmall, got %v", err)
+	}
+	if index.Len() != 1 {
+		t.Fatalf("expected 1 event, got %d", index.Len())
+	}
+	if offset, _ := file.Seek(0, 1); offset != 18678 {
+		t.Fatalf("expected offset 18678, got %d", offset)
+	}
+}
+
+func TestIndexReadAt(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A second copy of the event, 10 seconds later.
+	later := append([]byte{}, buf...)
+	binary.BigEndian.PutUint32(later[16:], binary.BigEndian.Uint32(later[16:])+10)
+	file := bytes.NewReader(concat(buf, later))
+
+	index, err := BuildIndex(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.NumRecords() != 34 {
+		t.Fatalf("expected 34 records, got %d", index.NumRecords())
+	}
+	first := time.Date(2000, 7, 28, 15, 40, 4, 267362000, time.UTC)
+	entry, err := index.Entry(18)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Offset != int64(len(buf))+68 || entry.Type != UNIFIED2_EXTRA_DATA ||
+		!entry.Time.Equal(first.Add(10*time.Second)) {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+	for _, n := range []int{-1, 34} {
+		if _, err := index.Entry(n); err == nil {
+			t.Fatalf("expected an error for entry %d", n)
+		}
+	}
+
+	// Each record read by number matches reading sequentially.
+	file.Seek(0, 0)
+	expected, err := ReadRecords(file, 34)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []int{33, 0, 17, 2} {
+		container, err := index.ReadAt(file, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(container.Record, expected[n].Record) {
+			t.Fatalf("record %d: expected %+v, got %+v", n, expected[n].Record,
+				container.Record)
+		}
+	}
+	if _, err := index.ReadAt(file, 34); err == nil {
+		t.Fatal("expected an error reading past the last record")
+	}
+
+	for _, search := range []struct {
+		time     time.Time
+		expected int
+	}{
+		{first.Add(-time.Second), 0},
+		{first, 0},
+		{first.Add(time.Second), 17},
+		{first.Add(10 * time.Second), 17},
+		{first.Add(11 * time.Second), 34},
+	} {
+		if n := index.Search(search.time); n != search.expected {
+			t.Fatalf("search %s: expected %d, got %d", search.time,
+				search.expected, n)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+//go:build go1.23
+
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"io"
+	"iter"
+)
+
+// Records returns an iterator over the remaining records, for use with
+// a range loop:
+//
+//	for container, err := range reader.Records() {
+//		if err != nil {
+//			...
+//		}
+//		...
+//	}
+//
+// Iteration ends without an error at the end of the input.  Any other
+// error, including io.ErrUnexpectedEOF for a partial record at the end
+// of the input, is yielded with a nil record and ends the iteration.
+func (r *Reader) Records() iter.Seq2[*RecordContainer, error] {
+	return func(yield func(*RecordContainer, error) bool) {
+		for {
+			container, err := r.Next()
+			if err == io.EOF {
+				return
+			}
+			if !yield(container, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Records returns an iterator over the remaining records, as
+// Reader.Records.
+//
+// Iteration ends without an error when the end of the file is reached
+// on a record boundary.  Any other error, including ErrBufferTooSmall
+// for an incomplete record at the end of the file, is yielded with a
+// nil record and ends the iteration.
+func (r *RecordReader) Records() iter.Seq2[*RecordContainer, error] {
+	return func(yield func(*RecordContainer, error) bool) {
+		for {
+			container, err := r.NextContainer()
+			if atEOF(err) {
+				return
+			}
+			if !yield(container, err) || err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+//go:build go1.23
+
+package unified2
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestReaderRecords(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for container, err := range NewReader(bytes.NewReader(buf)).Records() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if container == nil {
+			t.Fatal("unexpected nil record")
+		}
+		count++
+	}
+	if count != 17 {
+		t.Fatalf("expected 17 records, got %d", count)
+	}
+
+	// A partial record at the end is an error.
+	var last error
+	count = 0
+	for _, err := range NewReader(bytes.NewReader(buf[:100])).Records() {
+		last = err
+		count++
+	}
+	if count != 2 || last != io.ErrUnexpectedEOF {
+		t.Fatalf("expected 1 record and io.ErrUnexpectedEOF, got %d, %v",
+			count-1, last)
+	}
+
+	// Breaking out of the loop stops reading.
+	reader := NewReader(bytes.NewReader(buf))
+	for range reader.Records() {
+		break
+	}
+	if reader.Offset() != 68 {
+		t.Fatalf("expected offset 68, got %d", reader.Offset())
+	}
+}
+
+func TestRecordReaderRecords(t *testing.T) {
+	reader, err := NewRecordReader("test/multi-record-event.log", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	count := 0
+	for _, err := range reader.Records() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+	}
+	if count != 17 {
+		t.Fatalf("expected 17 records, got %d", count)
+	}
+}
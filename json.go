@@ -0,0 +1,198 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+)
+
+// The format of timestamps in JSON, RFC 3339 with microseconds.
+const jsonTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+func jsonTime(t time.Time) string {
+	return t.UTC().Format(jsonTimeFormat)
+}
+
+// recordTypeNames are the names of the record types used in JSON.
+var recordTypeNames = map[uint32]string{
+	UNIFIED2_PACKET:          "packet",
+	UNIFIED2_EVENT:           "event",
+	UNIFIED2_EVENT_IP6:       "event_ip6",
+	UNIFIED2_EVENT_V2:        "event_v2",
+	UNIFIED2_EVENT_V2_IP6:    "event_v2_ip6",
+	UNIFIED2_EXTRA_DATA:      "extra_data",
+	UNIFIED2_EVENT_APPID:     "event_appid",
+	UNIFIED2_EVENT_APPID_IP6: "event_appid_ip6",
+}
+
+// recordTypeName returns the name of a record type, or the type number
+// for unknown types.
+func recordTypeName(recordType uint32) string {
+	if name, ok := recordTypeNames[recordType]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", recordType)
+}
+
+// MarshalJSON encodes the event as a JSON object with snake case field
+// names, using the Suricata eve names src_ip, src_port, dest_ip,
+// dest_port and proto for the flow.  The time of the event is encoded
+// in RFC 3339 format as timestamp.  The inner_vlan_id, app_id and
+// trailer fields are only present if set, with the trailer base64
+// encoded.
+func (e *EventRecord) MarshalJSON() ([]byte, error) {
+	var innerVlanId *uint16
+	if e.hasInnerVlan {
+		innerVlanId = &e.InnerVlanId
+	}
+
+	return json.Marshal(struct {
+		SensorId          uint32  `json:"sensor_id"`
+		EventId           uint32  `json:"event_id"`
+		Timestamp         string  `json:"timestamp"`
+		SignatureId       uint32  `json:"signature_id"`
+		GeneratorId       uint32  `json:"generator_id"`
+		SignatureRevision uint32  `json:"signature_revision"`
+		ClassificationId  uint32  `json:"classification_id"`
+		Priority          uint32  `json:"priority"`
+		SrcIp             string  `json:"src_ip"`
+		SrcPort           uint16  `json:"src_port"`
+		DestIp            string  `json:"dest_ip"`
+		DestPort          uint16  `json:"dest_port"`
+		Proto             uint8   `json:"proto"`
+		ImpactFlag        uint8   `json:"impact_flag"`
+		Impact            uint8   `json:"impact"`
+		Blocked           uint8   `json:"blocked"`
+		MplsLabel         uint32  `json:"mpls_label"`
+		VlanId            uint16  `json:"vlan_id"`
+		InnerVlanId       *uint16 `json:"inner_vlan_id,omitempty"`
+		AppId             string  `json:"app_id,omitempty"`
+		Trailer           []byte  `json:"trailer,omitempty"`
+	}{
+		SensorId:          e.SensorId,
+		EventId:           e.EventId,
+		Timestamp:         jsonTime(e.Timestamp()),
+		SignatureId:       e.SignatureId,
+		GeneratorId:       e.GeneratorId,
+		SignatureRevision: e.SignatureRevision,
+		ClassificationId:  e.ClassificationId,
+		Priority:          e.Priority,
+		SrcIp:             ipString(e.IpSource),
+		SrcPort:           e.SportItype,
+		DestIp:            ipString(e.IpDestination),
+		DestPort:          e.DportIcode,
+		Proto:             e.Protocol,
+		ImpactFlag:        e.ImpactFlag,
+		Impact:            e.Impact,
+		Blocked:           e.Blocked,
+		MplsLabel:         e.MplsLabel,
+		VlanId:            e.VlanId,
+		InnerVlanId:       innerVlanId,
+		AppId:             e.AppId,
+		Trailer:           e.Trailer,
+	})
+}
+
+// ipString returns addr as a string, or an empty string if addr is
+// empty.
+func ipString(addr []byte) string {
+	if len(addr) == 0 {
+		return ""
+	}
+	return net.IP(addr).String()
+}
+
+// MarshalJSON encodes the packet as a JSON object with snake case field
+// names.  The capture time of the packet is encoded in RFC 3339 format
+// as timestamp and the packet data base64 encoded as packet, as in
+// Suricata eve.  The trailer field is only present if set.
+func (p *PacketRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		SensorId    uint32 `json:"sensor_id"`
+		EventId     uint32 `json:"event_id"`
+		EventSecond uint32 `json:"event_second"`
+		Timestamp   string `json:"timestamp"`
+		LinkType    uint32 `json:"linktype"`
+		Length      uint32 `json:"length"`
+		Packet      []byte `json:"packet"`
+		Trailer     []byte `json:"trailer,omitempty"`
+	}{
+		SensorId:    p.SensorId,
+		EventId:     p.EventId,
+		EventSecond: p.EventSecond,
+		Timestamp:   jsonTime(p.Timestamp()),
+		LinkType:    p.LinkType,
+		Length:      p.Length,
+		Packet:      p.Data,
+		Trailer:     p.Trailer,
+	})
+}
+
+// MarshalJSON encodes the extra data as a JSON object with snake case
+// field names.  The time of the event is encoded in RFC 3339 format as
+// timestamp and the data base64 encoded.  The trailer field is only
+// present if set.
+func (e *ExtraDataRecord) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		EventType   uint32 `json:"event_type"`
+		EventLength uint32 `json:"event_length"`
+		SensorId    uint32 `json:"sensor_id"`
+		EventId     uint32 `json:"event_id"`
+		Timestamp   string `json:"timestamp"`
+		Type        uint32 `json:"type"`
+		DataType    uint32 `json:"data_type"`
+		DataLength  uint32 `json:"data_length"`
+		Data        []byte `json:"data"`
+		Trailer     []byte `json:"trailer,omitempty"`
+	}{
+		EventType:   e.EventType,
+		EventLength: e.EventLength,
+		SensorId:    e.SensorId,
+		EventId:     e.EventId,
+		Timestamp:   jsonTime(e.Timestamp()),
+		Type:        e.Type,
+		DataType:    e.DataType,
+		DataLength:  e.DataLength,
+		Data:        e.Data,
+		Trailer:     e.Trailer,
+	})
+}
+
+// MarshalJSON encodes the container as a JSON object with the name of
+// the record type, such as "event_v2", "packet" or "extra_data", as
+// type and the record as record.  The source_name field is only present
+// if set.
+func (c *RecordContainer) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type       string      `json:"type"`
+		SourceName string      `json:"source_name,omitempty"`
+		Record     interface{} `json:"record"`
+	}{recordTypeName(c.Type), c.SourceName, c.Record})
+}
@@ -0,0 +1,69 @@
+package unified2
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// jsonTestContainers returns one container of each record type: the
+// event and first packet of the test file, and an extra data record.
+func jsonTestContainers(t *testing.T) []*RecordContainer {
+	input, err := os.Open("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	var containers []*RecordContainer
+	for i := 0; i < 3; i++ {
+		raw, err := ReadRawRecord(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		container, err := DecodeRawRecord(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if container.Type != UNIFIED2_EXTRA_DATA {
+			containers = append(containers, container)
+		}
+	}
+
+	return append(containers, &RecordContainer{
+		Type:       UNIFIED2_EXTRA_DATA,
+		SourceName: "unified2.log.1382627900",
+		Record: &ExtraDataRecord{
+			EventType:   4,
+			EventLength: 36,
+			EventId:     89,
+			EventSecond: 964798804,
+			Type:        EXTRA_DATA_TYPE_XFF_IPV4,
+			DataType:    1,
+			DataLength:  12,
+			Data:        []byte{10, 0, 0, 1},
+		},
+	})
+}
+
+func TestMarshalJSON(t *testing.T) {
+	var buf bytes.Buffer
+	for _, container := range jsonTestContainers(t) {
+		encoded, err := json.MarshalIndent(container, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(encoded)
+		buf.WriteString("\n")
+	}
+
+	golden, err := ioutil.ReadFile("test/records.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), golden) {
+		t.Fatalf("JSON does not match test/records.json:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,189 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The lengths of the event record layouts.
+var eventLayoutLens = map[uint32]int{
+	UNIFIED2_EVENT:           52,
+	UNIFIED2_EVENT_IP6:       76,
+	UNIFIED2_EVENT_V2:        60,
+	UNIFIED2_EVENT_V2_IP6:    84,
+	UNIFIED2_EVENT_APPID:     124,
+	UNIFIED2_EVENT_APPID_IP6: 148,
+}
+
+// The length of the MPLS and VLAN fields of V2 events.
+const v2FieldsLen = 8
+
+// The length of the optional inner VLAN fields of V2 events.
+const innerVlanLen = 4
+
+// Layout describes the expected length of a record body.
+type Layout struct {
+	// MinLen is the length of the fixed fields of the record type.
+	MinLen int
+
+	// HasVariablePayload is true for record types with data following
+	// the fixed fields, the packet data of packet records and the data
+	// of extra data records.
+	HasVariablePayload bool
+
+	// Len is the length the record body should have according to the
+	// length fields of the record.  For events this is MinLen, plus
+	// the inner VLAN fields of V2 events exactly that long if
+	// DecodeInnerVlan is enabled.
+	Len int
+}
+
+// RecordLayout returns the layout of a record of recordType with the
+// body data, for checking that the length of the record matches what
+// the decoders expect.  A record is well formed if len(data) equals
+// the Len of its layout, any other data being placed in the Trailer by
+// the decoders.
+//
+// ErrInvalidHeader is returned for unknown record types, and a
+// DecodeError if data is too short to contain the length fields of a
+// packet or extra data record.
+func RecordLayout(recordType uint32, data []byte) (Layout, error) {
+	switch recordType {
+	case UNIFIED2_PACKET:
+		layout := Layout{MinLen: PACKET_RECORD_HDR_LEN, HasVariablePayload: true}
+		if len(data) < layout.MinLen {
+			return layout, newDecodeError(recordType, "short packet header")
+		}
+		layout.Len = layout.MinLen + int(binary.BigEndian.Uint32(data[24:28]))
+		return layout, nil
+	case UNIFIED2_EXTRA_DATA:
+		layout := Layout{MinLen: EXTRA_DATA_RECORD_HDR_LEN, HasVariablePayload: true}
+		if len(data) < layout.MinLen {
+			return layout, newDecodeError(recordType, "short extra data header")
+		}
+		dataLength := binary.BigEndian.Uint32(data[28:32])
+		if dataLength < extraDataLengthOverhead {
+			return layout, newDecodeError(recordType, fmt.Sprintf(
+				"bad extra data length %d", dataLength))
+		}
+		layout.Len = layout.MinLen + int(dataLength-extraDataLengthOverhead)
+		return layout, nil
+	}
+
+	minLen, ok := eventLayoutLens[recordType]
+	if !ok {
+		return Layout{}, fmt.Errorf("%w: Unknown record type", ErrInvalidHeader)
+	}
+	layout := Layout{MinLen: minLen, Len: minLen}
+	if DecodeInnerVlan &&
+		(recordType == UNIFIED2_EVENT_V2 || recordType == UNIFIED2_EVENT_V2_IP6) &&
+		len(data) == minLen+innerVlanLen {
+		layout.Len += innerVlanLen
+	}
+	return layout, nil
+}
+
+// minRecordLen returns the minimum body length of a record of
+// recordType, the length of its fixed fields, except for V2 events
+// which may lack their MPLS and VLAN fields, see DecodeEventRecord.
+// Zero is returned for unknown record types.
+func minRecordLen(recordType uint32) int {
+	switch recordType {
+	case UNIFIED2_PACKET:
+		return PACKET_RECORD_HDR_LEN
+	case UNIFIED2_EXTRA_DATA:
+		return EXTRA_DATA_RECORD_HDR_LEN
+	case UNIFIED2_EVENT_V2, UNIFIED2_EVENT_V2_IP6:
+		return eventLayoutLens[recordType] - v2FieldsLen
+	}
+	return eventLayoutLens[recordType]
+}
+
+// checkMinLen returns a DecodeError if length is below the minimum
+// body length of a record of recordType.
+func checkMinLen(recordType uint32, length int) error {
+	minLen := minRecordLen(recordType)
+	if length < minLen {
+		return newDecodeError(recordType, fmt.Sprintf(
+			"record length %d below minimum of %d", length, minLen))
+	}
+	return nil
+}
+
+// DecodeMode selects how strictly the lengths of records are checked
+// against their layout, see RecordLayout, when reading.
+type DecodeMode int
+
+const (
+	// DecodeLenient accepts records longer than their layout, placing
+	// the bytes left over, such as padding, in the Trailer, and V2
+	// events without the optional MPLS and VLAN fields.  Only records
+	// too short for their fixed fields are rejected.  This is the
+	// default.
+	DecodeLenient DecodeMode = iota
+
+	// DecodeStrict rejects records whose length does not exactly
+	// match their layout with a DecodeError: events with bytes left
+	// over or fields missing, packets not matching their Length and
+	// extra data not matching their DataLength.
+	DecodeStrict
+)
+
+func (m DecodeMode) String() string {
+	switch m {
+	case DecodeLenient:
+		return "lenient"
+	case DecodeStrict:
+		return "strict"
+	}
+	return fmt.Sprintf("DecodeMode(%d)", int(m))
+}
+
+// checkLayout returns a DecodeError if a raw record is not of the
+// length of its layout, that is the record would be decoded with a
+// Trailer or with fields missing.
+func checkLayout(record *RawRecord) error {
+	layout, err := RecordLayout(record.Type, record.Data)
+	if err != nil {
+		return err
+	}
+	if layout.Len != len(record.Data) {
+		kind := "event"
+		switch record.Type {
+		case UNIFIED2_PACKET:
+			kind = "packet"
+		case UNIFIED2_EXTRA_DATA:
+			kind = "extra data"
+		}
+		return newDecodeError(record.Type, fmt.Sprintf(
+			"%s length %d does not match layout length %d",
+			kind, len(record.Data), layout.Len))
+	}
+	return nil
+}
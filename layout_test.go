@@ -0,0 +1,113 @@
+package unified2
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+// Every record of the test file must have the length of its layout.
+func TestRecordLayoutFile(t *testing.T) {
+	input, err := os.Open("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	for {
+		raw, err := ReadRawRecord(input)
+		if atEOF(err) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		layout, err := RecordLayout(raw.Type, raw.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if layout.Len != len(raw.Data) {
+			t.Fatalf("record type %d: expected length %d, got %d",
+				raw.Type, layout.Len, len(raw.Data))
+		}
+		if layout.HasVariablePayload != (raw.Type == UNIFIED2_PACKET ||
+			raw.Type == UNIFIED2_EXTRA_DATA) {
+			t.Fatalf("record type %d: unexpected layout %+v", raw.Type, layout)
+		}
+	}
+}
+
+func TestRecordLayout(t *testing.T) {
+	for recordType, expected := range map[uint32]int{
+		UNIFIED2_EVENT:           52,
+		UNIFIED2_EVENT_IP6:       76,
+		UNIFIED2_EVENT_V2:        60,
+		UNIFIED2_EVENT_V2_IP6:    84,
+		UNIFIED2_EVENT_APPID:     124,
+		UNIFIED2_EVENT_APPID_IP6: 148,
+	} {
+		layout, err := RecordLayout(recordType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if layout.MinLen != expected || layout.Len != expected ||
+			layout.HasVariablePayload {
+			t.Fatalf("record type %d: unexpected layout %+v", recordType, layout)
+		}
+	}
+
+	// The inner VLAN fields of V2 events, only if enabled.
+	layout, err := RecordLayout(UNIFIED2_EVENT_V2_IP6, make([]byte, 88))
+	if err != nil || layout.Len != 84 {
+		t.Fatalf("unexpected layout %+v, error %v", layout, err)
+	}
+	defer func(enabled bool) { DecodeInnerVlan = enabled }(DecodeInnerVlan)
+	DecodeInnerVlan = true
+	layout, err = RecordLayout(UNIFIED2_EVENT_V2_IP6, make([]byte, 88))
+	if err != nil || layout.Len != 88 {
+		t.Fatalf("unexpected layout %+v, error %v", layout, err)
+	}
+
+	if _, err := RecordLayout(UNIFIED2_PACKET, make([]byte, 10)); !errors.Is(err, DecodingError) {
+		t.Fatalf("expected DecodingError, got %v", err)
+	}
+	if _, err := RecordLayout(1, nil); !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf("expected ErrInvalidHeader, got %v", err)
+	}
+}
+
+// Records shorter than the fixed fields of their type are rejected
+// before decoding.
+func TestReadRecordMinLen(t *testing.T) {
+	for recordType, minLen := range map[uint32]int{
+		UNIFIED2_PACKET:          28,
+		UNIFIED2_EXTRA_DATA:      32,
+		UNIFIED2_EVENT:           52,
+		UNIFIED2_EVENT_IP6:       76,
+		UNIFIED2_EVENT_V2:        52,
+		UNIFIED2_EVENT_V2_IP6:    76,
+		UNIFIED2_EVENT_APPID:     124,
+		UNIFIED2_EVENT_APPID_IP6: 148,
+	} {
+		for _, length := range []int{minLen - 1, minLen} {
+			data := make([]byte, length)
+			if recordType == UNIFIED2_EXTRA_DATA && length == minLen {
+				// A valid DataLength.
+				data[31] = extraDataLengthOverhead
+			}
+			var buf bytes.Buffer
+			writeRawRecord(&buf, &RawRecord{recordType, data})
+			_, err := ReadRecord(bytes.NewReader(buf.Bytes()))
+			if length < minLen {
+				var decodeErr *DecodeError
+				if !errors.As(err, &decodeErr) || decodeErr.Type != recordType {
+					t.Fatalf("type %d, length %d: expected DecodeError, got %v",
+						recordType, length, err)
+				}
+			} else if err != nil {
+				t.Fatalf("type %d, length %d: unexpected error %v",
+					recordType, length, err)
+			}
+		}
+	}
+}
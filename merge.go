@@ -0,0 +1,206 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"container/heap"
+	"io"
+	"time"
+)
+
+// MergeReader reads records from several sources, such as the files of
+// several sensors, returning them ordered by event time, as for a
+// forensic timeline.
+//
+// Each source is expected to be ordered by event time itself, as a
+// unified2 file written by a single sensor is, the sources being
+// merged as in a k-way merge.  Packet and extra data records are
+// ordered by the time of the event they follow in their source, so
+// they stay with their event.  Records with the same time are returned
+// in the order of the sources given.
+//
+// MergeReaders should be created with NewMergeReader() or
+// OpenMergeReader().
+type MergeReader struct {
+	heap mergeHeap
+
+	// Sources whose next record is to be read, having been returned
+	// or not yet read from.
+	pending []*mergeSource
+
+	// Files opened by OpenMergeReader.
+	files []*FileReader
+}
+
+// mergeSource is a source of a MergeReader along with its next record.
+type mergeSource struct {
+	source RecordSource
+	index  int
+
+	// The next record and its time.
+	record interface{}
+	time   time.Time
+
+	// The key and time of the last event read from the source.
+	eventKey  EventKey
+	eventTime time.Time
+}
+
+// NewMergeReader creates a new MergeReader merging the records of
+// sources.
+func NewMergeReader(sources ...RecordSource) *MergeReader {
+	r := &MergeReader{}
+	for i, source := range sources {
+		r.pending = append(r.pending, &mergeSource{source: source, index: i})
+	}
+	return r
+}
+
+// OpenMergeReader opens the named unified2 files, as by OpenFile, and
+// returns a MergeReader merging their records.  The files are closed
+// by Close.
+func OpenMergeReader(filenames ...string) (*MergeReader, error) {
+	var files []*FileReader
+	var sources []RecordSource
+	for _, filename := range filenames {
+		file, err := OpenFile(filename)
+		if err != nil {
+			for _, file := range files {
+				file.Close()
+			}
+			return nil, err
+		}
+		files = append(files, file)
+		sources = append(sources, file.Source())
+	}
+
+	r := NewMergeReader(sources...)
+	r.files = files
+	return r, nil
+}
+
+// Next returns the next record in event time order, or io.EOF once all
+// sources have been read.  The end of a source is the end of file
+// error of the source, io.EOF or an ErrBufferTooSmall with
+// MissingBytes of 8, or a nil record.  Other errors are returned as is,
+// after which Next may be called again to retry reading the source.
+func (r *MergeReader) Next() (interface{}, error) {
+	for len(r.pending) > 0 {
+		if err := r.advance(r.pending[0]); err != nil {
+			return nil, err
+		}
+		r.pending = r.pending[1:]
+	}
+
+	if len(r.heap) == 0 {
+		return nil, io.EOF
+	}
+
+	// The next record of the source is only read on the following
+	// call, so a source is not read ahead of what is returned.
+	source := heap.Pop(&r.heap).(*mergeSource)
+	r.pending = append(r.pending, source)
+	return source.record, nil
+}
+
+// advance reads the next record of source onto the heap, unless at the
+// end of the source.
+func (r *MergeReader) advance(source *mergeSource) error {
+	record, err := source.source.Next()
+	if err != nil || record == nil {
+		if err == nil || err == io.EOF || atEOF(err) {
+			return nil
+		}
+		return err
+	}
+
+	source.record = record
+	source.time = source.recordTime(record)
+	heap.Push(&r.heap, source)
+	return nil
+}
+
+// recordTime returns the time record is ordered by.
+func (s *mergeSource) recordTime(record interface{}) time.Time {
+	if event, ok := record.(*EventRecord); ok {
+		s.eventKey = event.Key()
+		s.eventTime = event.Timestamp()
+		return s.eventTime
+	}
+
+	if recordKey(record) == s.eventKey && !s.eventTime.IsZero() {
+		return s.eventTime
+	}
+	switch record := record.(type) {
+	case *PacketRecord:
+		return time.Unix(int64(record.EventSecond), 0).UTC()
+	case *ExtraDataRecord:
+		return record.Timestamp()
+	}
+	return time.Time{}
+}
+
+// Close closes the files opened by OpenMergeReader.
+func (r *MergeReader) Close() error {
+	var err error
+	for _, file := range r.files {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// mergeHeap is a heap of sources ordered by the time of their next
+// record, then by their index.
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int {
+	return len(h)
+}
+
+func (h mergeHeap) Less(i, j int) bool {
+	if !h[i].time.Equal(h[j].time) {
+		return h[i].time.Before(h[j].time)
+	}
+	return h[i].index < h[j].index
+}
+
+func (h mergeHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *mergeHeap) Push(x interface{}) {
+	*h = append(*h, x.(*mergeSource))
+}
+
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	source := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return source
+}
@@ -0,0 +1,80 @@
+package unified2
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+// sliceSource is a RecordSource returning records from a slice.
+type sliceSource []interface{}
+
+func (s *sliceSource) Next() (interface{}, error) {
+	if len(*s) == 0 {
+		return nil, io.EOF
+	}
+	record := (*s)[0]
+	*s = (*s)[1:]
+	return record, nil
+}
+
+func TestMergeReader(t *testing.T) {
+	event := func(sensor, id, second uint32) *EventRecord {
+		return &EventRecord{SensorId: sensor, EventId: id, EventSecond: second}
+	}
+	packet := func(sensor, id, second uint32) *PacketRecord {
+		return &PacketRecord{SensorId: sensor, EventId: id, EventSecond: second,
+			PacketSecond: 100}
+	}
+
+	a := &sliceSource{event(1, 1, 10), packet(1, 1, 10), event(1, 2, 30),
+		packet(1, 2, 30)}
+	b := &sliceSource{packet(2, 1, 5), event(2, 2, 20), packet(2, 2, 20),
+		event(2, 3, 30)}
+	expected := []interface{}{(*b)[0], (*a)[0], (*a)[1], (*b)[1], (*b)[2],
+		(*a)[2], (*a)[3], (*b)[3]}
+
+	reader := NewMergeReader(a, b)
+	var records []interface{}
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Fatalf("unexpected order: %v", records)
+	}
+}
+
+func TestOpenMergeReader(t *testing.T) {
+	reader, err := OpenMergeReader("test/multi-record-event.log",
+		"test/multi-record-event.log.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	// Records with the same time are returned in the order of the
+	// files, keeping the packets with their event.
+	for i := 0; i < 34; i++ {
+		record, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := record.(*EventRecord); ok != (i == 0 || i == 17) {
+			t.Fatalf("unexpected record %d: %T", i, record)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+
+	if _, err := OpenMergeReader("test/multi-record-event.log",
+		"test/does-not-exist.log"); err == nil {
+		t.Fatal("expected an error opening a missing file")
+	}
+}
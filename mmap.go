@@ -0,0 +1,229 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// MappedReader reads records from a unified2 file mapped into memory,
+// for offline analysis of large files.  Records are decoded in place,
+// the data of the records referring to the mapping, so no record data
+// is copied and with NextInto no memory is allocated per record.
+//
+// The mapping is read-only, the data of records must not be modified,
+// and is only valid until the reader is closed.  Use the Copy method of
+// a record to keep it after closing the reader.  The file must not be
+// truncated while mapped, as accessing the mapping past the end of the
+// file crashes the program, so files still being written should be
+// read with a RecordReader instead.  On platforms without
+// memory mapping the file is read into memory instead.
+//
+// Compressed files can not be mapped, and are reported as
+// ErrUnsupportedCompression.
+//
+// MappedReaders should be created with OpenMappedReader().
+type MappedReader struct {
+	// DecodeMode selects how strictly the lengths of records are
+	// checked against their layout, see DecodeMode.  The default is
+	// DecodeLenient.
+	DecodeMode DecodeMode
+
+	// SourceName is the name of the file being read, it is set in
+	// each RecordContainer returned.
+	SourceName string
+
+	data   []byte
+	offset int64
+
+	// The raw record and decoded records reused by NextInto.
+	raw     RawRecord
+	buffers recordBuffers
+
+	stats readerStats
+}
+
+// OpenMappedReader maps filename into memory for reading.
+func OpenMappedReader(filename string) (*MappedReader, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if int64(int(size)) != size {
+		return nil, fmt.Errorf("%s: file too large to map", filename)
+	}
+
+	var data []byte
+	if size > 0 {
+		data, err = mapFile(file, int(size))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if bytes.HasPrefix(data, gzipMagic) || bytes.HasPrefix(data, zstdMagic) {
+		unmapFile(data)
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCompression, filename)
+	}
+
+	return &MappedReader{SourceName: filename, data: data}, nil
+}
+
+// ReadRawRecord returns the next raw record, with its Data referring to
+// the mapping.
+//
+// At the end of the file io.EOF is returned, or io.ErrUnexpectedEOF if
+// the file ends with a partial record, and ErrInvalidHeader if the
+// record type is not known.
+func (r *MappedReader) ReadRawRecord() (*RawRecord, error) {
+	raw := &RawRecord{}
+	if err := r.readRaw(raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// Next reads the next record and returns it decoded in a
+// RecordContainer.
+//
+// The errors returned are those of ReadRawRecord, and a DecodeError,
+// reported by errors.Is as ErrMalformedRecord and DecodingError, if the
+// record could not be decoded.
+func (r *MappedReader) Next() (*RecordContainer, error) {
+	container := &RecordContainer{}
+	if err := r.next(container, &RawRecord{}, nil); err != nil {
+		return nil, err
+	}
+	return container, nil
+}
+
+// NextInto reads the next record into container, as Next, reusing the
+// decoded record of the previous call to avoid allocating for every
+// record.
+//
+// The decoded record placed in container is owned by the reader and is
+// only valid until the next call to NextInto.
+func (r *MappedReader) NextInto(container *RecordContainer) error {
+	return r.next(container, &r.raw, &r.buffers)
+}
+
+func (r *MappedReader) next(container *RecordContainer, raw *RawRecord, buffers *recordBuffers) error {
+	offset := r.offset
+	if err := r.readRaw(raw); err != nil {
+		return err
+	}
+
+	err := decodeRawRecordInto(raw, container, buffers)
+	if err == nil && r.DecodeMode == DecodeStrict {
+		err = checkLayout(raw)
+	}
+	if err != nil {
+		r.stats.decodeError(false)
+		return withOffset(err, offset)
+	}
+	container.SourceName = r.SourceName
+	container.Offset = offset
+	container.Len = r.offset - offset
+	return nil
+}
+
+// readRaw reads the next raw record into raw.
+func (r *MappedReader) readRaw(raw *RawRecord) error {
+	buf := r.data[r.offset:]
+	if len(buf) == 0 {
+		return io.EOF
+	} else if len(buf) < rawHeaderLen {
+		return io.ErrUnexpectedEOF
+	}
+
+	recordType := binary.BigEndian.Uint32(buf[0:4])
+	length := binary.BigEndian.Uint32(buf[4:8])
+	if !validRecordType(recordType) {
+		return fmt.Errorf("%w: Unknown record type", ErrInvalidHeader)
+	}
+	if uint64(length) > uint64(len(buf)-rawHeaderLen) {
+		return io.ErrUnexpectedEOF
+	}
+
+	// Limit the capacity so the data can not be appended to in place.
+	end := rawHeaderLen + int(length)
+	raw.Type = recordType
+	raw.Data = buf[rawHeaderLen:end:end]
+	r.offset += int64(end)
+	r.stats.record(recordType, int64(end))
+	return nil
+}
+
+// Seek sets the offset of the next record read, as io.Seeker.
+func (r *MappedReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case 1:
+		offset += r.offset
+	case 2:
+		offset += int64(len(r.data))
+	}
+	if offset < 0 || offset > int64(len(r.data)) {
+		return r.offset, errors.New("MappedReader.Seek: offset out of range")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+// Offset returns the offset in the file of the next record.
+func (r *MappedReader) Offset() int64 {
+	return r.offset
+}
+
+// Stats returns the counters of the records read so far.  It may be
+// called while another goroutine is reading.
+func (r *MappedReader) Stats() ReaderStats {
+	return r.stats.get()
+}
+
+// Close unmaps the file.  Records read from the reader must not be used
+// after it is closed.
+func (r *MappedReader) Close() error {
+	data := r.data
+	r.data = nil
+	r.offset = 0
+	if data == nil {
+		return nil
+	}
+	return unmapFile(data)
+}
@@ -0,0 +1,126 @@
+package unified2
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestMappedReader(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := OpenMappedReader("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	// Records match those read with a Reader, with NextInto as well
+	// as Next.
+	expected := NewReader(bytes.NewReader(buf))
+	var container RecordContainer
+	for i := 0; i < 17; i++ {
+		want, err := expected.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := reader.Next
+		if i%2 == 1 {
+			next = func() (*RecordContainer, error) {
+				return &container, reader.NextInto(&container)
+			}
+		}
+		got, err := next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want.SourceName = "test/multi-record-event.log"
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("record %d: expected %+v, got %+v", i, want, got)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if reader.Offset() != int64(len(buf)) {
+		t.Fatalf("expected offset %d, got %d", len(buf), reader.Offset())
+	}
+	if stats := reader.Stats(); stats.NumRecords() != 17 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// Seek to the extra data record.
+	if _, err := reader.Seek(68, 0); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := reader.ReadRawRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Type != UNIFIED2_EXTRA_DATA || !bytes.Equal(raw.Data, buf[76:18678]) {
+		t.Fatalf("unexpected record type %d, length %d", raw.Type, len(raw.Data))
+	}
+
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMappedReaderErrors(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// A partial record at the end of the file.
+	filename := tmpdir + "/partial.log"
+	if err := ioutil.WriteFile(filename, buf[:100], 0644); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := OpenMappedReader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Next(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	// An empty file.
+	filename = tmpdir + "/empty.log"
+	if err := ioutil.WriteFile(filename, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	empty, err := OpenMappedReader(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer empty.Close()
+	if _, err := empty.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+
+	// Compressed files can not be mapped.
+	if _, err := OpenMappedReader("test/multi-record-event.log.gz"); !errors.Is(err, ErrUnsupportedCompression) {
+		t.Fatalf("expected ErrUnsupportedCompression, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+//go:build linux
+
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+)
+
+// inotifyWatcher waits for a file to be modified using inotify.
+type inotifyWatcher struct {
+	file *os.File
+	buf  []byte
+}
+
+// newWatcher returns a watcher for modifications of filename.
+func newWatcher(filename string) (watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := syscall.InotifyAddWatch(fd, filename, syscall.IN_MODIFY); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	// As the descriptor is non-blocking, reads of the file use the
+	// runtime poller and support deadlines.
+	return &inotifyWatcher{
+		file: os.NewFile(uintptr(fd), "inotify"),
+		buf:  make([]byte, 4096),
+	}, nil
+}
+
+func (w *inotifyWatcher) wait(ctx context.Context, timeout time.Duration) error {
+	// The timeout is set before watching ctx, so that a cancellation
+	// is not overwritten by it.
+	w.file.SetReadDeadline(time.Now().Add(timeout))
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.file.SetReadDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	// Whether an event was read or the timeout expired, the caller
+	// checks the file for more data.
+	w.file.Read(w.buf)
+	return ctx.Err()
+}
+
+func (w *inotifyWatcher) close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,346 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPacketTruncated is returned when the packet data ends before a
+// header being parsed.
+var ErrPacketTruncated = errors.New("Packet data truncated")
+
+// ErrUnsupportedLinkType is returned when parsing packet data of a
+// link type that is not supported.
+var ErrUnsupportedLinkType = errors.New("Packet link type not supported")
+
+// ErrUnsupportedProtocol is returned when parsing packet data of a
+// network or transport protocol that is not supported.
+var ErrUnsupportedProtocol = errors.New("Packet protocol not supported")
+
+// ErrNoPacketData is returned when a packet record has no packet data.
+var ErrNoPacketData = errors.New("Packet record has no packet data")
+
+// Link types of packet records, the LINKTYPE values used in pcap
+// files.  LINKTYPE_RAW is also logged as 12 or 14 on some platforms,
+// these are handled as raw IP too, see NormalizedLinkType.
+const (
+	LINKTYPE_NULL      = 0
+	LINKTYPE_ETHERNET  = 1
+	LINKTYPE_RAW       = 101
+	LINKTYPE_LINUX_SLL = 113
+)
+
+// The platform specific values of DLT_RAW.
+const (
+	dltRaw12 = 12
+	dltRaw14 = 14
+)
+
+// Link layer header lengths.
+const (
+	ethernetHeaderLen = 14
+	nullHeaderLen     = 4
+	linuxSllHeaderLen = 16
+)
+
+// Ethernet types.
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVlan = 0x8100
+	etherTypeQinQ = 0x88a8
+)
+
+// IP protocol numbers.
+const (
+	ipProtoHopOpts  = 0
+	ipProtoICMP     = 1
+	ipProtoTCP      = 6
+	ipProtoUDP      = 17
+	ipProtoRouting  = 43
+	ipProtoFragment = 44
+	ipProtoICMPv6   = 58
+	ipProtoDstOpts  = 60
+)
+
+// Copy returns a copy of the packet that does not share memory with
+// the packet or the data it was decoded from.
+func (p *PacketRecord) Copy() *PacketRecord {
+	packet := *p
+	packet.Data = copyBytes(p.Data)
+	packet.Trailer = copyBytes(p.Trailer)
+	return &packet
+}
+
+// String returns a one line summary of the packet, with its event,
+// link type and length.
+func (p *PacketRecord) String() string {
+	return fmt.Sprintf("packet event %d linktype %d length %d",
+		p.EventId, p.LinkType, p.Length)
+}
+
+// DataHash returns the SHA-256 hash of the captured packet data.
+//
+// Two packet records with the same hash carry the same packet, which
+// makes the hash suitable for removing duplicate packets, for example
+// when the same file has been spooled twice.  Only the packet data is
+// hashed, the record header fields such as EventId are not.
+func (p *PacketRecord) DataHash() [32]byte {
+	return sha256.Sum256(p.Data)
+}
+
+// PacketDeduper detects packet records that have already been seen
+// based on the hash of their data.
+//
+// PacketDeduper should be created with NewPacketDeduper().
+type PacketDeduper struct {
+	seen map[[32]byte]struct{}
+}
+
+// NewPacketDeduper creates a new PacketDeduper.
+func NewPacketDeduper() *PacketDeduper {
+	return &PacketDeduper{
+		seen: make(map[[32]byte]struct{}),
+	}
+}
+
+// Seen returns true if a packet with the same data has already been
+// passed to Seen, otherwise the packet is remembered and false is
+// returned.
+func (d *PacketDeduper) Seen(packet *PacketRecord) bool {
+	hash := packet.DataHash()
+	if _, ok := d.seen[hash]; ok {
+		return true
+	}
+	d.seen[hash] = struct{}{}
+	return false
+}
+
+// Reset forgets all packets seen so far.
+func (d *PacketDeduper) Reset() {
+	d.seen = make(map[[32]byte]struct{})
+}
+
+// NormalizedLinkType returns the LinkType of the packet as a LINKTYPE
+// value, as used in pcap files, mapping the platform specific values of
+// DLT_RAW, 12 and 14, to LINKTYPE_RAW.  Other link types are returned
+// unchanged.
+//
+// The LINKTYPE values are those of gopacket's layers.LinkType, so the
+// packet can be decoded with gopacket as:
+//
+//	data, linkType, err := packet.LinkData()
+//	if err != nil {
+//		...
+//	}
+//	decoded := gopacket.NewPacket(data, layers.LinkType(linkType),
+//		gopacket.Default)
+func (p *PacketRecord) NormalizedLinkType() uint32 {
+	switch p.LinkType {
+	case dltRaw12, dltRaw14:
+		return LINKTYPE_RAW
+	}
+	return p.LinkType
+}
+
+// LinkData returns the packet data, starting at the link layer header,
+// along with its NormalizedLinkType, for passing to a packet decoder.
+// ErrNoPacketData is returned if the record has no packet data.
+func (p *PacketRecord) LinkData() ([]byte, uint32, error) {
+	if len(p.Data) == 0 {
+		return nil, 0, ErrNoPacketData
+	}
+	return p.Data, p.NormalizedLinkType(), nil
+}
+
+// ParseIPHeader returns the packet data starting at the IP header.
+//
+// The link layer header is located based on the LinkType of the
+// packet, the supported link types being LINKTYPE_ETHERNET (with any
+// VLAN tags), LINKTYPE_LINUX_SLL, LINKTYPE_RAW and LINKTYPE_NULL.
+// ErrUnsupportedLinkType is returned for other link types, and
+// ErrUnsupportedProtocol if the packet is not IPv4 or IPv6.
+func (p *PacketRecord) ParseIPHeader() ([]byte, error) {
+	data := p.Data
+
+	switch p.NormalizedLinkType() {
+	case LINKTYPE_ETHERNET:
+		if len(data) < ethernetHeaderLen {
+			return nil, ErrPacketTruncated
+		}
+		etherType := binary.BigEndian.Uint16(data[12:14])
+		data = data[ethernetHeaderLen:]
+
+		// Skip over any VLAN tags.
+		for etherType == etherTypeVlan || etherType == etherTypeQinQ {
+			if len(data) < 4 {
+				return nil, ErrPacketTruncated
+			}
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+		return ipWithEtherType(data, etherType)
+	case LINKTYPE_LINUX_SLL:
+		if len(data) < linuxSllHeaderLen {
+			return nil, ErrPacketTruncated
+		}
+		etherType := binary.BigEndian.Uint16(data[14:16])
+		return ipWithEtherType(data[linuxSllHeaderLen:], etherType)
+	case LINKTYPE_NULL:
+		// The address family is in host byte order of the capturing
+		// host and its value for IPv6 differs between platforms, so
+		// the IP version is used instead.
+		if len(data) < nullHeaderLen {
+			return nil, ErrPacketTruncated
+		}
+		return ipWithVersion(data[nullHeaderLen:])
+	case LINKTYPE_RAW:
+		return ipWithVersion(data)
+	}
+
+	return nil, ErrUnsupportedLinkType
+}
+
+// ipWithEtherType returns data if etherType is IPv4 or IPv6.
+func ipWithEtherType(data []byte, etherType uint16) ([]byte, error) {
+	switch etherType {
+	case etherTypeIPv4, etherTypeIPv6:
+		return data, nil
+	}
+	return nil, ErrUnsupportedProtocol
+}
+
+// ipWithVersion returns data if it starts with an IPv4 or IPv6 header.
+func ipWithVersion(data []byte) ([]byte, error) {
+	if len(data) < 1 {
+		return nil, ErrPacketTruncated
+	}
+	switch data[0] >> 4 {
+	case 4, 6:
+		return data, nil
+	}
+	return nil, ErrUnsupportedProtocol
+}
+
+// transportHeader returns the transport protocol and the packet data
+// starting at the transport header of the IP packet in data.
+func transportHeader(data []byte) (uint8, []byte, error) {
+	if len(data) < 1 {
+		return 0, nil, ErrPacketTruncated
+	}
+
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return 0, nil, ErrPacketTruncated
+		}
+		headerLen := int(data[0]&0x0f) * 4
+		if headerLen < 20 || len(data) < headerLen {
+			return 0, nil, ErrPacketTruncated
+		}
+		// Only the first fragment contains the transport header.
+		if binary.BigEndian.Uint16(data[6:8])&0x1fff != 0 {
+			return 0, nil, ErrUnsupportedProtocol
+		}
+		return data[9], data[headerLen:], nil
+	case 6:
+		if len(data) < 40 {
+			return 0, nil, ErrPacketTruncated
+		}
+		next := data[6]
+		data = data[40:]
+		for {
+			switch next {
+			case ipProtoHopOpts, ipProtoRouting, ipProtoDstOpts:
+				if len(data) < 2 {
+					return 0, nil, ErrPacketTruncated
+				}
+				headerLen := (int(data[1]) + 1) * 8
+				if len(data) < headerLen {
+					return 0, nil, ErrPacketTruncated
+				}
+				next = data[0]
+				data = data[headerLen:]
+			case ipProtoFragment:
+				if len(data) < 8 {
+					return 0, nil, ErrPacketTruncated
+				}
+				if binary.BigEndian.Uint16(data[2:4])&0xfff8 != 0 {
+					return 0, nil, ErrUnsupportedProtocol
+				}
+				next = data[0]
+				data = data[8:]
+			default:
+				return next, data, nil
+			}
+		}
+	}
+
+	return 0, nil, ErrUnsupportedProtocol
+}
+
+// ParsePorts returns the TCP or UDP source and destination ports of
+// the captured packet.
+//
+// The link types supported are those of ParseIPHeader.
+// ErrUnsupportedLinkType or ErrUnsupportedProtocol is returned for
+// packets of other link types, non-IP packets, non-TCP/UDP packets and
+// non-first fragments.
+// ErrPacketTruncated is returned if the packet data ends before the
+// ports.
+func (p *PacketRecord) ParsePorts() (srcPort, dstPort uint16, err error) {
+	ip, err := p.ParseIPHeader()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	protocol, transport, err := transportHeader(ip)
+	if err != nil {
+		return 0, 0, err
+	}
+	if protocol != ipProtoTCP && protocol != ipProtoUDP {
+		return 0, 0, ErrUnsupportedProtocol
+	}
+	if len(transport) < 4 {
+		return 0, 0, ErrPacketTruncated
+	}
+
+	return binary.BigEndian.Uint16(transport[0:2]),
+		binary.BigEndian.Uint16(transport[2:4]), nil
+}
+
+// Timestamp returns the time the packet was captured as a time.Time in
+// UTC.
+func (p *PacketRecord) Timestamp() time.Time {
+	return time.Unix(int64(p.PacketSecond),
+		int64(p.PacketMicrosecond)*int64(time.Microsecond)).UTC()
+}
@@ -0,0 +1,235 @@
+package unified2
+
+import (
+	"testing"
+)
+
+func TestPacketDeduper(t *testing.T) {
+	a := &PacketRecord{EventId: 1, Data: []byte("packet one")}
+	b := &PacketRecord{EventId: 2, Data: []byte("packet one")}
+	c := &PacketRecord{EventId: 1, Data: []byte("packet two")}
+
+	if a.DataHash() != b.DataHash() {
+		t.Fatal("expected packets with the same data to have the same hash")
+	}
+	if a.DataHash() == c.DataHash() {
+		t.Fatal("expected packets with different data to have different hashes")
+	}
+
+	deduper := NewPacketDeduper()
+	if deduper.Seen(a) {
+		t.Fatal("first packet should not have been seen")
+	}
+	if !deduper.Seen(b) {
+		t.Fatal("duplicate packet should have been seen")
+	}
+	if deduper.Seen(c) {
+		t.Fatal("different packet should not have been seen")
+	}
+
+	deduper.Reset()
+	if deduper.Seen(a) {
+		t.Fatal("packet should not have been seen after reset")
+	}
+}
+
+// An Ethernet/IPv4/TCP packet from port 1234 to port 80, truncated
+// after the TCP ports.
+var ethernetIPv4TCP = []byte{
+	// Ethernet.
+	0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x00, 0x01, 0x02, 0x03, 0x04, 0x06,
+	0x08, 0x00,
+	// IPv4.
+	0x45, 0x00, 0x00, 0x28, 0x00, 0x01, 0x00, 0x00, 0x40, 0x06, 0x00, 0x00,
+	0x0a, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x02,
+	// TCP ports.
+	0x04, 0xd2, 0x00, 0x50,
+}
+
+// An Ethernet/IPv6/UDP packet from port 53 to port 5353, truncated
+// after the UDP ports.
+var ethernetIPv6UDP = []byte{
+	// Ethernet.
+	0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x00, 0x01, 0x02, 0x03, 0x04, 0x06,
+	0x86, 0xdd,
+	// IPv6.
+	0x60, 0x00, 0x00, 0x00, 0x00, 0x08, 0x11, 0x40,
+	0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+	0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2,
+	// UDP ports.
+	0x00, 0x35, 0x14, 0xe9,
+}
+
+func TestPacketRecordParsePorts(t *testing.T) {
+	tests := []struct {
+		data    []byte
+		srcPort uint16
+		dstPort uint16
+	}{
+		{ethernetIPv4TCP, 1234, 80},
+		{ethernetIPv6UDP, 53, 5353},
+	}
+
+	for _, test := range tests {
+		packet := &PacketRecord{LinkType: 1, Data: test.data}
+		srcPort, dstPort, err := packet.ParsePorts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if srcPort != test.srcPort || dstPort != test.dstPort {
+			t.Fatalf("expected ports %d -> %d, got %d -> %d", test.srcPort,
+				test.dstPort, srcPort, dstPort)
+		}
+
+		packet.Data = test.data[:len(test.data)-2]
+		if _, _, err := packet.ParsePorts(); err != ErrPacketTruncated {
+			t.Fatalf("expected ErrPacketTruncated, got %v", err)
+		}
+	}
+
+	packet := &PacketRecord{LinkType: 1000, Data: ethernetIPv4TCP}
+	if _, _, err := packet.ParsePorts(); err != ErrUnsupportedLinkType {
+		t.Fatalf("expected ErrUnsupportedLinkType, got %v", err)
+	}
+}
+
+// The ports parsed from the first packet of the test file should
+// match those of the event.
+func TestPacketRecordParsePortsFile(t *testing.T) {
+	reader, err := NewRecordReader("test/multi-record-event.log", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	var event *EventRecord
+	for {
+		record, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch record := record.(type) {
+		case *EventRecord:
+			event = record
+		case *PacketRecord:
+			srcPort, dstPort, err := record.ParsePorts()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if srcPort != event.SportItype || dstPort != event.DportIcode {
+				t.Fatalf("expected ports %d -> %d, got %d -> %d",
+					event.SportItype, event.DportIcode, srcPort, dstPort)
+			}
+			return
+		}
+	}
+}
+
+func TestPacketRecordCopy(t *testing.T) {
+	data := make([]byte, PACKET_RECORD_HDR_LEN+6)
+	data[PACKET_RECORD_HDR_LEN-1] = 4
+	copy(data[PACKET_RECORD_HDR_LEN:], "datatr")
+
+	packet, err := DecodePacketRecord(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packetCopy := packet.Copy()
+
+	// Reuse the buffer the packet was decoded from.
+	copy(data[PACKET_RECORD_HDR_LEN:], "XXXXXX")
+
+	if string(packet.Data) != "XXXX" {
+		t.Fatalf("expected the decoded packet to refer to the buffer")
+	}
+	if string(packetCopy.Data) != "data" || string(packetCopy.Trailer) != "tr" {
+		t.Fatalf("unexpected packet copy data %q, trailer %q",
+			packetCopy.Data, packetCopy.Trailer)
+	}
+}
+
+// The IPv4 and IPv6 test packets with other link layer headers.
+var (
+	linuxSllIPv4TCP = append([]byte{
+		0x00, 0x00, 0x00, 0x01, 0x00, 0x06, 0x00, 0x01,
+		0x02, 0x03, 0x04, 0x06, 0x00, 0x00, 0x08, 0x00,
+	}, ethernetIPv4TCP[14:]...)
+	nullIPv4TCP = append([]byte{0x02, 0x00, 0x00, 0x00},
+		ethernetIPv4TCP[14:]...)
+	nullIPv6UDP = append([]byte{0x1e, 0x00, 0x00, 0x00},
+		ethernetIPv6UDP[14:]...)
+	rawIPv6UDP = ethernetIPv6UDP[14:]
+)
+
+func TestPacketRecordParsePortsLinkTypes(t *testing.T) {
+	tests := []struct {
+		linkType uint32
+		data     []byte
+		srcPort  uint16
+		dstPort  uint16
+	}{
+		{LINKTYPE_LINUX_SLL, linuxSllIPv4TCP, 1234, 80},
+		{LINKTYPE_NULL, nullIPv4TCP, 1234, 80},
+		{LINKTYPE_NULL, nullIPv6UDP, 53, 5353},
+		{LINKTYPE_RAW, rawIPv6UDP, 53, 5353},
+		{12, rawIPv6UDP, 53, 5353},
+	}
+
+	for _, test := range tests {
+		packet := &PacketRecord{LinkType: test.linkType, Data: test.data}
+		ip, err := packet.ParseIPHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ip[0]>>4 != 4 && ip[0]>>4 != 6 {
+			t.Fatalf("link type %d: IP header not found", test.linkType)
+		}
+		srcPort, dstPort, err := packet.ParsePorts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if srcPort != test.srcPort || dstPort != test.dstPort {
+			t.Fatalf("link type %d: expected ports %d -> %d, got %d -> %d",
+				test.linkType, test.srcPort, test.dstPort, srcPort, dstPort)
+		}
+	}
+
+	packet := &PacketRecord{LinkType: LINKTYPE_RAW, Data: []byte{0x00}}
+	if _, err := packet.ParseIPHeader(); err != ErrUnsupportedProtocol {
+		t.Fatalf("expected ErrUnsupportedProtocol, got %v", err)
+	}
+}
+
+func TestPacketRecordString(t *testing.T) {
+	packet := &PacketRecord{EventId: 89, LinkType: LINKTYPE_ETHERNET, Length: 227}
+	expected := "packet event 89 linktype 1 length 227"
+	if packet.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, packet.String())
+	}
+}
+
+func TestPacketRecordLinkData(t *testing.T) {
+	for linkType, expected := range map[uint32]uint32{
+		LINKTYPE_ETHERNET: LINKTYPE_ETHERNET,
+		LINKTYPE_RAW:      LINKTYPE_RAW,
+		12:                LINKTYPE_RAW,
+		14:                LINKTYPE_RAW,
+		LINKTYPE_NULL:     LINKTYPE_NULL,
+		228:               228,
+	} {
+		packet := &PacketRecord{LinkType: linkType, Data: []byte{0x45}}
+		data, normalized, err := packet.LinkData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if normalized != expected || len(data) != 1 {
+			t.Fatalf("link type %d: expected %d, got %d", linkType,
+				expected, normalized)
+		}
+	}
+
+	packet := &PacketRecord{LinkType: LINKTYPE_ETHERNET}
+	if _, _, err := packet.LinkData(); err != ErrNoPacketData {
+		t.Fatalf("expected ErrNoPacketData, got %v", err)
+	}
+}
@@ -0,0 +1,410 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jasonish/go-unified2/internal/zstd"
+)
+
+// The minimum number of bytes requested from the underlying reader of
+// a Reader.
+const readerChunkLen = 64 * 1024
+
+// The number of reads returning no data and no error after which a
+// Reader gives up with io.ErrNoProgress, as bufio does.
+const maxEmptyReads = 100
+
+// Reader reads unified2 records from an io.Reader, such as a pipe,
+// socket or gzip stream, that does not support seeking.
+//
+// Data is buffered internally instead of seeking back on a partial
+// record, so a read may be retried after more data becomes available.
+//
+// Compressed input is detected from its first bytes, gzip and zstd
+// compressed input being decompressed transparently, so archived files
+// can be read directly.  Offsets are then those of the decompressed
+// data.
+//
+// Readers should be created with NewReader().
+type Reader struct {
+	// MaxRecordLen is the maximum length of a record, longer records
+	// are returned as a RecordTooLargeError.  If 0 DefaultMaxRecordLen is
+	// used.
+	MaxRecordLen uint32
+
+	// DecodeMode selects how strictly the lengths of records are
+	// checked against their layout, see DecodeMode.  The default is
+	// DecodeLenient.
+	DecodeMode DecodeMode
+
+	reader io.Reader
+
+	// The input passed to NewReader, closed by Close.
+	input io.Reader
+
+	// Data read but not yet returned as a record.
+	buf []byte
+
+	// The offset in the input of the start of buf.
+	offset int64
+
+	// Whether the start of the input has been checked for
+	// compression.
+	sniffed bool
+
+	// The gzip reader decompressing the input, if compressed.
+	gzip *gzip.Reader
+
+	stats readerStats
+}
+
+// ErrUnsupportedCompression is returned for compressed input that can
+// not be read, such as a compressed file opened with OpenMappedReader.
+var ErrUnsupportedCompression = errors.New("Unified2 input compressed with unsupported format")
+
+// The magic bytes a gzip stream starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// The magic bytes a zstd frame starts with.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// NewReader creates a new Reader reading from reader.
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{reader: reader, input: reader}
+}
+
+// ReadRawRecord reads the next raw record.
+//
+// At the end of the input io.EOF is returned, or io.ErrUnexpectedEOF if
+// the input ends with a partial record.  The partial record is kept,
+// so if more data becomes available, such as from a file still being
+// written, a following call will return the complete record.
+// ErrInvalidHeader is returned if the record type is not known and a
+// RecordTooLargeError if the record is longer than the MaxRecordLen.
+func (r *Reader) ReadRawRecord() (*RawRecord, error) {
+	if !r.sniffed {
+		if err := r.sniff(); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		raw, rest, err := NextRawRecord(r.buf)
+		if err == nil {
+			r.offset += int64(len(r.buf) - len(rest))
+			r.buf = rest
+			r.stats.record(raw.Type, rawHeaderLen+int64(len(raw.Data)))
+			return &RawRecord{raw.Type, copyBytes(raw.Data)}, nil
+		}
+
+		missing := &ErrBufferTooSmall{}
+		if !errors.As(err, &missing) {
+			return nil, err
+		}
+		if err := r.checkLen(); err != nil {
+			return nil, err
+		}
+
+		if err := r.fill(int(missing.MissingBytes)); err != nil {
+			if err == io.EOF {
+				if len(r.buf) > 0 {
+					return nil, io.ErrUnexpectedEOF
+				}
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+	}
+}
+
+// RecordHeader is the header of a record returned by Reader.Peek,
+// along with its time if available.
+type RecordHeader struct {
+	Type uint32
+	Len  uint32
+
+	// Time is the time of the record, as by the Timestamp method of
+	// its record type, if the start of the record has been buffered,
+	// otherwise it is zero.
+	Time time.Time
+}
+
+// Peek returns the header of the next record without consuming it, so
+// a caller may decide whether to read the record now or later.  Only
+// the header is waited for, the time of the record is only returned if
+// it has already been read along with the header.
+//
+// The errors returned are those of ReadRawRecord, io.EOF and
+// io.ErrUnexpectedEOF referring to the header only.
+func (r *Reader) Peek() (RecordHeader, error) {
+	if !r.sniffed {
+		if err := r.sniff(); err != nil {
+			return RecordHeader{}, err
+		}
+	}
+
+	for len(r.buf) < rawHeaderLen {
+		if err := r.fill(rawHeaderLen - len(r.buf)); err != nil {
+			if err == io.EOF && len(r.buf) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return RecordHeader{}, err
+		}
+	}
+
+	header := RecordHeader{
+		Type: binary.BigEndian.Uint32(r.buf[0:4]),
+		Len:  binary.BigEndian.Uint32(r.buf[4:8]),
+	}
+	if !validRecordType(header.Type) {
+		return header, fmt.Errorf("%w: Unknown record type", ErrInvalidHeader)
+	}
+	if err := r.checkLen(); err != nil {
+		return header, err
+	}
+
+	body := r.buf[rawHeaderLen:]
+	if uint32(len(body)) > header.Len {
+		body = body[:header.Len]
+	}
+	header.Time = peekTime(header.Type, body)
+	return header, nil
+}
+
+// peekTime returns the time of a record of recordType from the start
+// of its body, or zero if too short.
+func peekTime(recordType uint32, body []byte) time.Time {
+	// The offsets of the seconds and microseconds of the time.
+	secOffset, usecOffset := 8, 12
+	switch recordType {
+	case UNIFIED2_PACKET:
+		secOffset, usecOffset = 12, 16
+	case UNIFIED2_EXTRA_DATA:
+		secOffset, usecOffset = 16, -1
+	}
+	if len(body) < secOffset+4 || len(body) < usecOffset+4 {
+		return time.Time{}
+	}
+
+	var usec uint32
+	if usecOffset >= 0 {
+		usec = binary.BigEndian.Uint32(body[usecOffset:])
+	}
+	return time.Unix(int64(binary.BigEndian.Uint32(body[secOffset:])),
+		int64(usec)*int64(time.Microsecond)).UTC()
+}
+
+// ReadRecord reads the next record and returns it decoded, as one of
+// the types EventRecord, PacketRecord or ExtraDataRecord.
+//
+// The errors returned are those of Next.
+func (r *Reader) ReadRecord() (interface{}, error) {
+	container, err := r.Next()
+	if err != nil {
+		return nil, err
+	}
+	return container.Record, nil
+}
+
+// Source returns a RecordSource reading records from r as ReadRecord,
+// for reading with an AggregateReader or MergeReader.  The source is an
+// io.Closer closing r.
+func (r *Reader) Source() RecordSource {
+	return readerSource{r}
+}
+
+// readerSource is a RecordSource reading from a Reader.
+type readerSource struct {
+	reader *Reader
+}
+
+func (s readerSource) Next() (interface{}, error) {
+	return s.reader.ReadRecord()
+}
+
+func (s readerSource) Close() error {
+	return s.reader.Close()
+}
+
+// Next reads the next record and returns it decoded in a
+// RecordContainer.
+//
+// The errors returned are those of ReadRawRecord, and a DecodeError,
+// reported by errors.Is as ErrMalformedRecord and DecodingError, if the
+// record could not be decoded.
+func (r *Reader) Next() (*RecordContainer, error) {
+	raw, err := r.ReadRawRecord()
+	if err != nil {
+		return nil, err
+	}
+
+	container, err := DecodeRawRecord(raw)
+	if err == nil && r.DecodeMode == DecodeStrict {
+		err = checkLayout(raw)
+	}
+	offset := r.offset - rawHeaderLen - int64(len(raw.Data))
+	if err != nil {
+		r.stats.decodeError(false)
+		return nil, withOffset(err, offset)
+	}
+	container.Offset = offset
+	return container, nil
+}
+
+// ReadRecords reads and decodes up to n records, as Next, for feeding
+// batch oriented consumers.
+//
+// If an error occurs before n records have been read, the records read
+// so far are returned along with the error, including io.EOF at the
+// end of the input.
+func (r *Reader) ReadRecords(n int) ([]*RecordContainer, error) {
+	records := make([]*RecordContainer, 0, n)
+	for len(records) < n {
+		container, err := r.Next()
+		if err != nil {
+			return records, err
+		}
+		records = append(records, container)
+	}
+	return records, nil
+}
+
+// Stats returns the counters of the records read so far.  It may be
+// called while another goroutine is reading.
+func (r *Reader) Stats() ReaderStats {
+	return r.stats.get()
+}
+
+// Close closes the gzip reader decompressing the input, if any, and
+// then the input of the reader, if it is an io.Closer, returning the
+// first error.
+func (r *Reader) Close() error {
+	var err error
+	if r.gzip != nil {
+		err = r.gzip.Close()
+	}
+	if closer, ok := r.input.(io.Closer); ok {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Offset returns the offset in the input of the next record, that is
+// the number of bytes of the records read so far.
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// sniff checks the start of the input for compression, decompressing
+// gzip and zstd input.  Unified2 input always starts with a zero byte, the high
+// byte of the record type, so can not be mistaken for compressed data.
+// If the input ends before enough has been read to tell, sniff is
+// tried again on the next read.
+func (r *Reader) sniff() error {
+	for len(r.buf) < len(zstdMagic) {
+		if err := r.fill(len(zstdMagic) - len(r.buf)); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+	r.sniffed = true
+
+	switch {
+	case bytes.HasPrefix(r.buf, gzipMagic):
+		gz, err := gzip.NewReader(io.MultiReader(bytes.NewReader(r.buf), r.reader))
+		if err != nil {
+			return err
+		}
+		r.reader = gz
+		r.gzip = gz
+		r.buf = nil
+	case bytes.HasPrefix(r.buf, zstdMagic):
+		r.reader = zstd.NewReader(io.MultiReader(bytes.NewReader(r.buf), r.reader))
+		r.buf = nil
+	}
+	return nil
+}
+
+// checkLen returns a RecordTooLargeError if the buffered data starts
+// with a header of a record longer than the MaxRecordLen.
+func (r *Reader) checkLen() error {
+	if len(r.buf) < rawHeaderLen {
+		return nil
+	}
+
+	maxLen := r.MaxRecordLen
+	if maxLen == 0 {
+		maxLen = DefaultMaxRecordLen
+	}
+
+	length := binary.BigEndian.Uint32(r.buf[4:8])
+	if length > maxLen {
+		return &RecordTooLargeError{binary.BigEndian.Uint32(r.buf[0:4]),
+			r.offset, length, maxLen}
+	}
+	return nil
+}
+
+// fill reads more data into the buffer, with room for at least need
+// more bytes.  An error is only returned if no data was read, reads
+// returning neither data nor an error being retried up to
+// maxEmptyReads times before io.ErrNoProgress is returned.
+func (r *Reader) fill(need int) error {
+	if cap(r.buf)-len(r.buf) < need {
+		size := need
+		if size < readerChunkLen {
+			size = readerChunkLen
+		}
+		buf := make([]byte, len(r.buf), len(r.buf)+size)
+		copy(buf, r.buf)
+		r.buf = buf
+	}
+
+	for i := 0; i < maxEmptyReads; i++ {
+		n, err := r.reader.Read(r.buf[len(r.buf):cap(r.buf)])
+		r.buf = r.buf[:len(r.buf)+n]
+		if n > 0 {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return io.ErrNoProgress
+}
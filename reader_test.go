@@ -0,0 +1,218 @@
+package unified2
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestReader(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := NewReader(iotest.OneByteReader(bytes.NewReader(buf)))
+	for i := 0; i < 17; i++ {
+		if _, err := reader.ReadRecord(); err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+	}
+	if _, err := reader.ReadRecord(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+// A partial record is kept and returned once the rest of it is
+// available.
+func TestReaderPartialRecord(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := bytes.NewBuffer(append([]byte{}, buf[:100]...))
+	reader := NewReader(input)
+
+	if _, err := reader.ReadRecord(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.ReadRecord(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	input.Write(buf[100:])
+	record, err := reader.ReadRecord()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := record.(*ExtraDataRecord); !ok {
+		t.Fatalf("expected an extra data record, got %T", record)
+	}
+}
+
+func TestReaderNext(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := NewReader(bytes.NewReader(buf))
+	expected := readContainers(t, "test/multi-record-event.log")
+	for i, e := range expected {
+		container, err := reader.Next()
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+		if container.Type != e.Type || !reflect.DeepEqual(container.Record, e.Record) {
+			t.Fatalf("record %d: expected %v, got %v", i, e, container)
+		}
+		if i == 0 && reader.Offset() != 68 {
+			t.Fatalf("expected offset 68, got %d", reader.Offset())
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if reader.Offset() != int64(len(buf)) {
+		t.Fatalf("expected offset %d, got %d", len(buf), reader.Offset())
+	}
+}
+
+func TestReaderCompressed(t *testing.T) {
+	plain, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, filename := range []string{
+		"test/multi-record-event.log.gz",
+		"test/multi-record-event.log.zst",
+	} {
+		compressed, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := NewReader(bytes.NewReader(plain))
+		reader := NewReader(iotest.OneByteReader(bytes.NewReader(compressed)))
+		for {
+			want, wantErr := expected.Next()
+			got, err := reader.Next()
+			if err != wantErr {
+				t.Fatalf("%s: expected error %v, got %v", filename, wantErr, err)
+			}
+			if wantErr != nil {
+				break
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: expected %+v, got %+v", filename, want, got)
+			}
+		}
+		if reader.Offset() != int64(len(plain)) {
+			t.Fatalf("%s: expected offset %d, got %d", filename, len(plain), reader.Offset())
+		}
+	}
+
+	// A corrupt zstd frame is reported, not read as records.
+	zstd := append([]byte{0x28, 0xb5, 0x2f, 0xfd}, make([]byte, 20)...)
+	reader := NewReader(bytes.NewReader(zstd))
+	if record, err := reader.Next(); err == nil {
+		t.Fatalf("expected an error, got %v", record)
+	}
+
+	// Input shorter than the magic bytes is not mistaken for anything.
+	reader = NewReader(bytes.NewReader([]byte{0x1f}))
+	if _, err := reader.Next(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestReaderReadRecords(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := NewReader(bytes.NewReader(buf))
+	records, err := reader.ReadRecords(10)
+	if err != nil || len(records) != 10 {
+		t.Fatalf("expected 10 records, got %d, %v", len(records), err)
+	}
+	records, err = reader.ReadRecords(10)
+	if err != io.EOF || len(records) != 7 {
+		t.Fatalf("expected 7 records and io.EOF, got %d, %v", len(records), err)
+	}
+	if records[6].Offset+records[6].Len != int64(len(buf)) {
+		t.Fatalf("unexpected last record %+v", records[6])
+	}
+}
+
+func TestReaderPeek(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := NewReader(bytes.NewReader(buf))
+	for i := 0; i < 17; i++ {
+		header, err := reader.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		container, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Type != container.Type || int64(header.Len)+8 != container.Len {
+			t.Fatalf("record %d: unexpected header %+v", i, header)
+		}
+		expected := container.Record.(interface{ Timestamp() time.Time }).Timestamp()
+		if !header.Time.Equal(expected) {
+			t.Fatalf("record %d: expected time %s, got %s", i, expected,
+				header.Time)
+		}
+	}
+	if _, err := reader.Peek(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+
+	// Only the header is needed, the time is not known from the
+	// header alone.
+	reader = NewReader(bytes.NewReader(buf[:10]))
+	header, err := reader.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Type != UNIFIED2_EVENT_V2 || header.Len != 60 || !header.Time.IsZero() {
+		t.Fatalf("unexpected header %+v", header)
+	}
+	if _, err := reader.Next(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	reader = NewReader(bytes.NewReader(buf[:5]))
+	if _, err := reader.Peek(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+// emptyReader returns neither data nor an error.
+type emptyReader struct{}
+
+func (emptyReader) Read(p []byte) (int, error) {
+	return 0, nil
+}
+
+// A reader making no progress is reported instead of being read
+// forever.
+func TestReaderNoProgress(t *testing.T) {
+	reader := NewReader(emptyReader{})
+	if _, err := reader.Next(); err != io.ErrNoProgress {
+		t.Fatalf("expected io.ErrNoProgress, got %v", err)
+	}
+}
@@ -27,15 +27,154 @@
 package unified2
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"time"
 )
 
+// ErrTimestampOrder is the warning passed to a RecordReader
+// WarningHook when an event is older than the previous event.
+var ErrTimestampOrder = errors.New("Unified2 event out of order")
+
+// ErrLengthMismatch is the warning or error reported by a
+// RecordReader when a length field of a record does not match the
+// amount of data in the record.
+var ErrLengthMismatch = errors.New("Unified2 record length mismatch")
+
+// ErrShortEvent is the warning passed to a RecordReader WarningHook
+// when an event record one byte shorter than its header claims was
+// compensated for, see CompatShortEvents.
+var ErrShortEvent = errors.New("Unified2 event record one byte short")
+
+// LengthPolicy controls how a RecordReader handles records with
+// length fields that do not match the amount of data in the record.
+type LengthPolicy int
+
+const (
+	// LengthIgnore ignores length mismatches.  This is the default.
+	LengthIgnore LengthPolicy = iota
+
+	// LengthWarn passes length mismatches to the WarningHook.
+	LengthWarn
+
+	// LengthError returns length mismatches as an error from
+	// Next.  The record in question will have been consumed.
+	LengthError
+)
+
+// RecordSource is a source of decoded unified2 records, such as a
+// RecordReader or SpoolRecordReader.
+type RecordSource interface {
+	// Next returns the next record, which will be one of the types
+	// EventRecord, PacketRecord or ExtraDataRecord, or a RawRecord
+	// for an unknown type if enabled by the source.
+	Next() (interface{}, error)
+}
+
+// readSeekerSource is a RecordSource reading from an io.ReadSeeker.
+type readSeekerSource struct {
+	file io.ReadSeeker
+}
+
+func (s *readSeekerSource) Next() (interface{}, error) {
+	return ReadRecord(s.file)
+}
+
 // RecordReader reads and decodes unified2 records from a file.
 //
 // RecordReaders should be created with NewRecordReader().
 type RecordReader struct {
+	// File is the file being read.  It is read through a buffer, so
+	// it must not be read or seeked directly while reading, use the
+	// Seek method of the reader instead.
 	File *os.File
+
+	// SourceName is the name of the file being read, it is set in
+	// each RecordContainer returned by NextContainer.
+	SourceName string
+
+	// WarningHook will be called with problems detected while
+	// reading that are not severe enough to stop reading.
+	WarningHook func(error)
+
+	// CheckTimestamps enables a warning, passed to WarningHook, if
+	// an event record is older than the previous event record.  Only
+	// event records are checked as packet and extra data records
+	// carry the time of the packet capture and event respectively.
+	CheckTimestamps bool
+
+	// LengthPolicy selects how records with a packet Length or
+	// extra data DataLength not matching the record data are
+	// handled.
+	LengthPolicy LengthPolicy
+
+	// UseEventLength enables using the EventLength of extra data
+	// records to frame the record data.  Any data following
+	// EventLength is placed in the Trailer of the record, and an
+	// EventLength not matching the record length is reported
+	// according to LengthPolicy.
+	UseEventLength bool
+
+	// CompatShortEvents enables reading files from older Suricata
+	// builds that wrote event records one byte shorter than the length
+	// in their record header, leaving out the final padding byte, which
+	// desyncs the stream.  Such an event is detected by the header
+	// following it being invalid while a valid record starts one byte
+	// earlier.  The event is then read with the missing byte as zero
+	// and reading continues with the following record.  As this is only
+	// detected by the following record, a short event at the end of the
+	// file is reported as incomplete.  Disabled by default.
+	CompatShortEvents bool
+
+	// MaxRecordLen is the maximum length of a record, longer records
+	// are returned as a RecordTooLargeError.  If 0 DefaultMaxRecordLen is
+	// used.
+	MaxRecordLen uint32
+
+	// SkipCorrupt enables skipping over records that can not be
+	// decoded.  The DecodeError of a skipped record is passed to the
+	// WarningHook and reading continues with the following record.
+	// Records longer than MaxRecordLen are not skipped, as their
+	// length can not be trusted.
+	SkipCorrupt bool
+
+	// DecodeMode selects how strictly the lengths of records are
+	// checked against their layout, see DecodeMode.  Records rejected
+	// by DecodeStrict are returned as a DecodeError, or skipped with
+	// SkipCorrupt.  The default is DecodeLenient.
+	DecodeMode DecodeMode
+
+	// ReturnUnknown enables returning records of unknown types, as
+	// written by newer versions of Snort, as a *RawRecord instead of
+	// returning ErrInvalidHeader, so they may be logged, counted or
+	// forwarded.  The length in the header of an unknown record is
+	// trusted to skip it, so on corrupt input reading may continue
+	// from the middle of a record.  Disabled by default.
+	ReturnUnknown bool
+
+	lastEventTime time.Time
+
+	// The offset the reader was opened at and the number of records
+	// read since, for progress estimates.
+	startOffset int64
+	records     int
+
+	// The record buffer and decoded records reused by NextInto.
+	buf     []byte
+	buffers recordBuffers
+
+	// The File read through a buffer.
+	buffered *bufferedFile
+
+	// The counters of Stats, or those shared with the
+	// SpoolRecordReader reading the file if sharedStats is set.
+	stats       readerStats
+	sharedStats *readerStats
 }
 
 // NewRecordReader creates a new RecordReader using the provided
@@ -61,24 +200,327 @@ func NewRecordReader(filename string, offset int64) (*RecordReader, error) {
 		}
 	}
 
-	return &RecordReader{file}, nil
+	return &RecordReader{
+		File:        file,
+		SourceName:  filename,
+		startOffset: offset,
+		buffered:    &bufferedFile{file: file, offset: offset},
+	}, nil
+}
+
+// input returns the File read through a buffer, so that reading
+// records does not make several system calls per record.
+func (r *RecordReader) input() *bufferedFile {
+	if r.buffered == nil {
+		r.buffered = &bufferedFile{file: r.File}
+		r.buffered.offset, _ = r.File.Seek(0, 1)
+	}
+	return r.buffered
+}
+
+// counters returns the counters records read are counted in.
+func (r *RecordReader) counters() *readerStats {
+	if r.sharedStats != nil {
+		return r.sharedStats
+	}
+	return &r.stats
+}
+
+// Seek sets the offset of the next record read, as by io.Seeker.  The
+// offset should be the start of a record.
+func (r *RecordReader) Seek(offset int64, whence int) (int64, error) {
+	return r.input().Seek(offset, whence)
 }
 
 // Next reads and returns the next unified2 record.  The record is
 // returned as an interface{} which will be one of the types
-// EventRecord, PacketRecord or ExtraDataRecord.
+// EventRecord, PacketRecord or ExtraDataRecord, or RawRecord for
+// records of unknown types if ReturnUnknown is set.
 func (r *RecordReader) Next() (interface{}, error) {
-	return ReadRecord(r.File)
+	container, err := r.NextContainer()
+	if err != nil {
+		return nil, err
+	}
+	return container.Record, nil
+}
+
+// NextContainer reads and returns the next unified2 record in a
+// RecordContainer, along with its type and the SourceName of this
+// reader.
+func (r *RecordReader) NextContainer() (*RecordContainer, error) {
+	container := &RecordContainer{}
+	if err := r.next(container, false); err != nil {
+		return nil, err
+	}
+	return container, nil
+}
+
+// ReadRecords reads and decodes up to n records, as NextContainer, for
+// feeding batch oriented consumers.
+//
+// If an error occurs before n records have been read, the records read
+// so far are returned along with the error, including the end of file
+// ErrBufferTooSmall.
+func (r *RecordReader) ReadRecords(n int) ([]*RecordContainer, error) {
+	records := make([]*RecordContainer, 0, n)
+	for len(records) < n {
+		container, err := r.NextContainer()
+		if err != nil {
+			return records, err
+		}
+		records = append(records, container)
+	}
+	return records, nil
+}
+
+// NextInto reads the next unified2 record into container, as
+// NextContainer, reusing the memory of the previous record to avoid
+// allocating for every record.
+//
+// The decoded record placed in container, and the Data and Trailer it
+// refers to, are owned by the reader and are only valid until the next
+// call to NextInto.  Use the Copy method of the record to keep it for
+// longer.
+func (r *RecordReader) NextInto(container *RecordContainer) error {
+	return r.next(container, true)
+}
+
+// NextContext reads and returns the next record as NextContainer, but
+// waits for the record to be written if the end of the file is
+// reached, as when following a file still being written.
+//
+// While waiting the file is checked for more data every PollInterval.
+// If ctx is cancelled first ctx.Err() is returned.
+func (r *RecordReader) NextContext(ctx context.Context) (*RecordContainer, error) {
+	for {
+		container, err := r.NextContainer()
+		e := &ErrBufferTooSmall{}
+		if !errors.As(err, &e) {
+			return container, err
+		}
+
+		if err := sleepContext(ctx, PollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (r *RecordReader) next(container *RecordContainer, reuse bool) error {
+	maxLen := r.MaxRecordLen
+	if maxLen == 0 {
+		maxLen = DefaultMaxRecordLen
+	}
+
+	var buf []byte
+	var buffers *recordBuffers
+	if reuse {
+		buf = r.buf
+		buffers = &r.buffers
+	}
+
+	var raw *RawRecord
+	var data []byte
+	var framingErr error
+	var offset, end int64
+	for {
+		var err error
+		raw, err = readRawRecord(r.input(), maxLen, buf, r.ReturnUnknown)
+		if err != nil {
+			return err
+		}
+		if reuse {
+			buf = raw.Data
+			r.buf = buf
+		}
+
+		if !validRecordType(raw.Type) {
+			end, _ := r.input().Seek(0, 1)
+			container.Type = raw.Type
+			container.Record = raw
+			container.SourceName = r.SourceName
+			container.Len = rawHeaderLen + int64(len(raw.Data))
+			container.Offset = end - container.Len
+			r.counters().record(raw.Type, container.Len)
+			r.records++
+			return nil
+		}
+
+		short := false
+		if r.CompatShortEvents && isEventType(raw.Type) {
+			short = r.compensateShortEvent(raw)
+		}
+
+		// A short event is one byte shorter in the file than its
+		// data.
+		end, _ = r.input().Seek(0, 1)
+		offset = end - rawHeaderLen - int64(len(raw.Data))
+		if short {
+			offset++
+		}
+		r.counters().record(raw.Type, end-offset)
+
+		data = raw.Data
+		framingErr = nil
+		if r.UseEventLength && raw.Type == UNIFIED2_EXTRA_DATA {
+			raw, framingErr = frameExtraData(raw)
+		}
+
+		err = decodeRawRecordInto(raw, container, buffers)
+		if err == nil && r.DecodeMode == DecodeStrict {
+			// Check the data before any framing by EventLength.
+			err = checkLayout(&RawRecord{raw.Type, data})
+		}
+		if err == nil {
+			break
+		}
+
+		err = withOffset(err, offset)
+		r.counters().decodeError(r.SkipCorrupt)
+		if !r.SkipCorrupt {
+			return err
+		}
+		r.warn(err)
+	}
+
+	container.SourceName = r.SourceName
+	container.Offset = offset
+	container.Len = end - offset
+	record := container.Record
+	r.records++
+
+	if extra, ok := record.(*ExtraDataRecord); ok && len(raw.Data) < len(data) {
+		extra.Trailer = data[EXTRA_DATA_RECORD_HDR_LEN+len(extra.Data):]
+	}
+
+	if event, ok := record.(*EventRecord); ok && r.CheckTimestamps {
+		timestamp := event.Timestamp()
+		if timestamp.Before(r.lastEventTime) {
+			r.warn(fmt.Errorf("%w: event %d at %s is before %s",
+				ErrTimestampOrder, event.EventId, timestamp,
+				r.lastEventTime))
+		}
+		r.lastEventTime = timestamp
+	}
+
+	if r.LengthPolicy != LengthIgnore {
+		for _, err := range []error{framingErr, checkLengths(record)} {
+			if err == nil {
+				continue
+			}
+			if r.LengthPolicy == LengthError {
+				r.counters().decodeError(false)
+				return err
+			}
+			r.warn(err)
+		}
+	}
+
+	return nil
+}
+
+// compensateShortEvent checks if the event record just read was
+// written one byte short, in which case the last byte read belongs to
+// the following record header.  If so the file is moved back a byte
+// and the missing padding byte of the event is set to zero, returning
+// true.
+func (r *RecordReader) compensateShortEvent(raw *RawRecord) bool {
+	input := r.input()
+	offset, err := input.Seek(0, 1)
+	if err != nil || len(raw.Data) == 0 {
+		return false
+	}
+
+	if _, err := PeekType(input); !errors.Is(err, ErrInvalidHeader) {
+		return false
+	}
+	if !isRecordAt(input, offset-1) {
+		input.Seek(offset, 0)
+		return false
+	}
+
+	input.Seek(offset-1, 0)
+	raw.Data[len(raw.Data)-1] = 0
+	r.warn(fmt.Errorf("%w: record ending at offset %d", ErrShortEvent,
+		offset-1))
+	return true
+}
+
+// frameExtraData limits the data of a raw extra data record to its
+// EventLength, if valid, returning an error describing any mismatch
+// between the EventLength and record length.
+func frameExtraData(raw *RawRecord) (*RawRecord, error) {
+	if len(raw.Data) < 8 {
+		return raw, nil
+	}
+
+	eventLength := binary.BigEndian.Uint32(raw.Data[4:8])
+	if eventLength == uint32(len(raw.Data)) {
+		return raw, nil
+	}
+
+	err := fmt.Errorf("%w: extra data EventLength %d, record length %d",
+		ErrLengthMismatch, eventLength, len(raw.Data))
+	if eventLength >= EXTRA_DATA_RECORD_HDR_LEN &&
+		eventLength < uint32(len(raw.Data)) {
+		raw = &RawRecord{raw.Type, raw.Data[:eventLength]}
+	}
+	return raw, err
+}
+
+// checkLengths returns an error if the length fields of a decoded
+// record do not match the data of the record.
+func checkLengths(record interface{}) error {
+	switch record := record.(type) {
+	case *PacketRecord:
+		if uint32(len(record.Data)) != record.Length ||
+			len(record.Trailer) > 0 {
+			return fmt.Errorf("%w: packet Length %d, have %d bytes",
+				ErrLengthMismatch, record.Length,
+				len(record.Data)+len(record.Trailer))
+		}
+	case *ExtraDataRecord:
+		if record.DataLength < extraDataLengthOverhead ||
+			record.DataLength-extraDataLengthOverhead != uint32(len(record.Data)) ||
+			len(record.Trailer) > 0 {
+			return fmt.Errorf("%w: extra data DataLength %d, have %d bytes",
+				ErrLengthMismatch, record.DataLength,
+				len(record.Data)+len(record.Trailer)+extraDataLengthOverhead)
+		}
+	}
+	return nil
+}
+
+func (r *RecordReader) warn(err error) {
+	if r.WarningHook != nil {
+		r.WarningHook(err)
+	}
+}
+
+// Resync advances the reader to the next plausible record after
+// corrupt data, as by Resync, limiting record lengths to the
+// MaxRecordLen.  The number of bytes skipped is returned.
+func (r *RecordReader) Resync() (int64, error) {
+	maxLen := r.MaxRecordLen
+	if maxLen == 0 {
+		maxLen = DefaultMaxRecordLen
+	}
+	return resync(r.input(), maxLen)
+}
+
+// Stats returns the counters of the records read so far.  It may be
+// called while another goroutine is reading.
+func (r *RecordReader) Stats() ReaderStats {
+	return r.counters().get()
 }
 
 // Close closes this reader and the underlying file.
-func (r *RecordReader) Close() {
-	r.File.Close()
+func (r *RecordReader) Close() error {
+	return r.File.Close()
 }
 
 // Offset returns the current offset of this reader.
 func (r *RecordReader) Offset() int64 {
-	offset, err := r.File.Seek(0, 1)
+	offset, err := r.input().Seek(0, 1)
 	if err != nil {
 		return 0
 	}
@@ -95,3 +537,27 @@ func (r *RecordReader) Exists() bool {
 	_, err := os.Stat(r.File.Name())
 	return err == nil
 }
+
+// ProgressEstimate returns the fraction of a file of fileSize bytes
+// that has been read, and an estimate of the total number of records
+// in the file based on the average size of the records read so far.
+//
+// The estimated total is 0 until a record has been read.
+func (r *RecordReader) ProgressEstimate(fileSize int64) (done float64, estTotal int) {
+	offset := r.Offset()
+	if fileSize <= 0 {
+		return 0, 0
+	}
+
+	done = float64(offset) / float64(fileSize)
+	if done > 1 {
+		done = 1
+	}
+
+	if r.records > 0 && offset > r.startOffset {
+		average := float64(offset-r.startOffset) / float64(r.records)
+		estTotal = int(float64(fileSize)/average + 0.5)
+	}
+
+	return done, estTotal
+}
@@ -0,0 +1,577 @@
+package unified2
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecordReaderCheckTimestamps(t *testing.T) {
+	// The second copy of the events in the x2 file has the same
+	// timestamp as the first, so should not generate a warning.
+	reader, err := NewRecordReader("test/multi-record-event-x2.log", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	var warnings []error
+	reader.WarningHook = func(err error) {
+		warnings = append(warnings, err)
+	}
+	reader.CheckTimestamps = true
+
+	for {
+		_, err := reader.Next()
+		if err != nil {
+			break
+		}
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+
+	// Pretend we have seen an event far in the future.
+	reader.Seek(0, 0)
+	reader.lastEventTime = reader.lastEventTime.AddDate(1, 0, 0)
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 1 || !errors.Is(warnings[0], ErrTimestampOrder) {
+		t.Fatalf("expected ErrTimestampOrder warning, got %v", warnings)
+	}
+}
+
+func TestCheckLengths(t *testing.T) {
+	// All the records in the test file have correct lengths.
+	reader, err := NewRecordReader("test/multi-record-event.log", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	reader.LengthPolicy = LengthError
+	for i := 0; i < 17; i++ {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []interface{}{
+		&PacketRecord{Length: 4, Data: []byte{1, 2, 3}},
+		&PacketRecord{Length: 2, Data: []byte{1, 2}, Trailer: []byte{3}},
+		&ExtraDataRecord{DataLength: 4},
+		&ExtraDataRecord{DataLength: 12, Data: []byte{1, 2, 3}},
+	}
+	for _, test := range tests {
+		if err := checkLengths(test); !errors.Is(err, ErrLengthMismatch) {
+			t.Fatalf("expected ErrLengthMismatch for %+v, got %v", test, err)
+		}
+	}
+
+	valid := &ExtraDataRecord{DataLength: 12, Data: []byte{1, 2, 3, 4}}
+	if err := checkLengths(valid); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecordReaderProgressEstimate(t *testing.T) {
+	reader, err := NewRecordReader("test/multi-record-event-x2.log", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	info, err := reader.File.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done, total := reader.ProgressEstimate(info.Size())
+	if done != 0 || total != 0 {
+		t.Fatalf("expected no progress, got %f, %d", done, total)
+	}
+
+	// Read the first copy of the file, the estimate should then be
+	// exact.
+	for i := 0; i < 17; i++ {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done, total = reader.ProgressEstimate(info.Size())
+	if done != 0.5 || total != 34 {
+		t.Fatalf("expected 0.5 done of 34 records, got %f, %d", done, total)
+	}
+}
+
+func TestRecordReaderUseEventLength(t *testing.T) {
+	// An extra data record with an EventLength of 36, 4 bytes short
+	// of the record length of 40.
+	data := make([]byte, 40)
+	binary.BigEndian.PutUint32(data[4:], 36)
+	binary.BigEndian.PutUint32(data[28:], 12)
+
+	raw, err := frameExtraData(&RawRecord{UNIFIED2_EXTRA_DATA, data})
+	if !errors.Is(err, ErrLengthMismatch) {
+		t.Fatalf("expected ErrLengthMismatch, got %v", err)
+	}
+	if len(raw.Data) != 36 {
+		t.Fatalf("expected record data to be framed to 36 bytes, got %d",
+			len(raw.Data))
+	}
+
+	// The records in the test file have a correct EventLength.
+	reader, err := NewRecordReader("test/multi-record-event.log", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	reader.UseEventLength = true
+	reader.LengthPolicy = LengthError
+	for i := 0; i < 17; i++ {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRecordReaderCompatShortEvents(t *testing.T) {
+	data, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop the last padding byte of the first event.
+	short := append(append([]byte{}, data[:67]...), data[68:]...)
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := tmpdir + "/short.log"
+	if err := ioutil.WriteFile(filename, short, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewRecordReader(filename, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	reader.Next()
+	if _, err := reader.Next(); !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf("expected ErrInvalidHeader without compatibility, got %v", err)
+	}
+
+	reader.Seek(0, 0)
+	reader.CompatShortEvents = true
+	warnings := 0
+	reader.WarningHook = func(err error) {
+		if !errors.Is(err, ErrShortEvent) {
+			t.Fatalf("unexpected warning %v", err)
+		}
+		warnings++
+	}
+	for i := 0; i < 17; i++ {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := reader.Next(); !atEOF(err) {
+		t.Fatalf("expected end of file, got %v", err)
+	}
+	if warnings != 1 {
+		t.Fatalf("expected 1 warning, got %d", warnings)
+	}
+}
+
+func TestRecordReaderNextContainer(t *testing.T) {
+	reader, err := NewRecordReader("test/multi-record-event.log", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	container, err := reader.NextContainer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.SourceName != "test/multi-record-event.log" {
+		t.Fatalf("unexpected source name %q", container.SourceName)
+	}
+	if _, ok := container.Record.(*EventRecord); !ok ||
+		container.Type != UNIFIED2_EVENT_V2 {
+		t.Fatalf("unexpected record %+v", container)
+	}
+}
+
+func TestRecordReaderMaxRecordLen(t *testing.T) {
+	reader, err := NewRecordReader("test/multi-record-event.log", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	// The extra data record is 18602 bytes long.
+	reader.MaxRecordLen = 1024
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = reader.Next()
+	if !errors.Is(err, DecodingError) {
+		t.Fatalf("expected DecodingError, got %v", err)
+	}
+	var tooLarge *RecordTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("expected RecordTooLargeError, got %v", err)
+	}
+	expected := RecordTooLargeError{UNIFIED2_EXTRA_DATA, 68, 18602, 1024}
+	if *tooLarge != expected {
+		t.Fatalf("expected %+v, got %+v", expected, tooLarge)
+	}
+
+	// A corrupt header claiming 4GB is not allocated.
+	header := []byte{0, 0, 0, UNIFIED2_PACKET, 0xff, 0xff, 0xff, 0xff}
+	if _, err := ReadRawRecord(bytes.NewReader(header)); !errors.As(err, &tooLarge) {
+		t.Fatalf("expected RecordTooLargeError, got %v", err)
+	}
+}
+
+// NextInto must return the same records as NextContainer.
+func TestRecordReaderNextInto(t *testing.T) {
+	expected, err := NewRecordReader("test/multi-record-event.log", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer expected.Close()
+	reader, err := NewRecordReader("test/multi-record-event.log", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	var container RecordContainer
+	count := 0
+	for {
+		err := reader.NextInto(&container)
+		if atEOF(err) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		next, err := expected.NextContainer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(&container, next) {
+			t.Fatalf("record %d: expected %+v, got %+v", count, next,
+				container)
+		}
+		count++
+	}
+	if count != 17 {
+		t.Fatalf("expected 17 records, got %d", count)
+	}
+}
+
+func BenchmarkRecordReaderNextContainer(b *testing.B) {
+	benchmarkRecordReader(b, func(r *RecordReader, c *RecordContainer) error {
+		_, err := r.NextContainer()
+		return err
+	})
+}
+
+func BenchmarkRecordReaderNextInto(b *testing.B) {
+	benchmarkRecordReader(b, func(r *RecordReader, c *RecordContainer) error {
+		return r.NextInto(c)
+	})
+}
+
+// benchmarkRecordReader reads all the records of the test file with
+// next for each iteration.
+func benchmarkRecordReader(b *testing.B, next func(*RecordReader, *RecordContainer) error) {
+	reader, err := NewRecordReader("test/multi-record-event.log", 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer reader.Close()
+
+	var container RecordContainer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		reader.Seek(0, 0)
+		for {
+			err := next(reader, &container)
+			if atEOF(err) {
+				break
+			} else if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestRecordReaderSkipCorrupt(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := tmpdir + "/corrupt.log"
+	if err := ioutil.WriteFile(filename, corruptLog(t), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewRecordReader(filename, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	reader.Next()
+	var decodeErr *DecodeError
+	if _, err := reader.Next(); !errors.As(err, &decodeErr) || decodeErr.Offset != 68 {
+		t.Fatalf("expected DecodeError at offset 68, got %v", err)
+	}
+
+	reader.Seek(0, 0)
+	reader.SkipCorrupt = true
+	var warnings []error
+	reader.WarningHook = func(err error) {
+		warnings = append(warnings, err)
+	}
+	for i := 0; i < 17; i++ {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := reader.Next(); !atEOF(err) {
+		t.Fatalf("expected end of file, got %v", err)
+	}
+	if len(warnings) != 1 || !errors.As(warnings[0], &decodeErr) ||
+		decodeErr.Offset != 68 {
+		t.Fatalf("unexpected warnings %v", warnings)
+	}
+}
+
+func TestRecordReaderStrict(t *testing.T) {
+	data, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first event with two bytes left over.
+	event := append(append([]byte{}, data[:68]...), 0xde, 0xad)
+	binary.BigEndian.PutUint32(event[4:8], 62)
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := tmpdir + "/strict.log"
+	if err := ioutil.WriteFile(filename, append(event, data[68:]...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewRecordReader(filename, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	for i := 0; i < 17; i++ {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader.Seek(0, 0)
+	reader.DecodeMode = DecodeStrict
+	var decodeErr *DecodeError
+	if _, err := reader.Next(); !errors.As(err, &decodeErr) ||
+		decodeErr.Offset != 0 || decodeErr.Type != UNIFIED2_EVENT_V2 {
+		t.Fatalf("expected DecodeError at offset 0, got %v", err)
+	}
+	for i := 0; i < 16; i++ {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The legacy V2 event without the MPLS and VLAN fields.
+	reader, err = NewRecordReader("test/event-v2-no-mpls.log", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	reader.DecodeMode = DecodeStrict
+	if _, err := reader.Next(); !errors.Is(err, DecodingError) {
+		t.Fatalf("expected DecodingError, got %v", err)
+	}
+}
+
+func TestRecordReaderNextContext(t *testing.T) {
+	reader, err := NewRecordReader("test/multi-record-event.log", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	defer func(interval time.Duration) {
+		PollInterval = interval
+	}(PollInterval)
+	PollInterval = time.Millisecond
+
+	ctx := context.Background()
+	for i := 0; i < 17; i++ {
+		if _, err := reader.NextContext(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := reader.NextContext(timeout); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestRecordReaderReturnUnknown(t *testing.T) {
+	data, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown := []byte{0, 0, 0, 200, 0, 0, 0, 5, 1, 2, 3, 4, 5}
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := tmpdir + "/unknown.log"
+	if err := ioutil.WriteFile(filename, concat(data[:68], unknown, data[68:]), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewRecordReader(filename, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Next(); !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf("expected ErrInvalidHeader, got %v", err)
+	}
+
+	reader.ReturnUnknown = true
+	container, err := reader.NextContainer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &RawRecord{200, []byte{1, 2, 3, 4, 5}}
+	if container.Type != 200 || !reflect.DeepEqual(container.Record, expected) {
+		t.Fatalf("unexpected record %+v", container)
+	}
+
+	// Reading continues with the following record.
+	container, err = reader.NextContainer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.Type != UNIFIED2_EXTRA_DATA {
+		t.Fatalf("unexpected record type %d", container.Type)
+	}
+}
+
+func TestRecordReaderDecodeMode(t *testing.T) {
+	data, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first packet with a byte of padding.
+	packet := append(append([]byte{}, data[18678:18678+263]...), 0)
+	binary.BigEndian.PutUint32(packet[4:8], 256)
+	padded := concat(data[:18678], packet, data[18678+263:])
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := tmpdir + "/padded.log"
+	if err := ioutil.WriteFile(filename, padded, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []DecodeMode{DecodeLenient, DecodeStrict} {
+		reader, err := NewRecordReader(filename, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		reader.DecodeMode = mode
+
+		for i := 0; i < 17; i++ {
+			_, err := reader.Next()
+			if mode == DecodeStrict && i == 2 {
+				var decodeErr *DecodeError
+				if !errors.As(err, &decodeErr) || decodeErr.Offset != 18678 ||
+					decodeErr.Type != UNIFIED2_PACKET {
+					t.Fatalf("expected DecodeError at offset 18678, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("%s: record %d: %v", mode, i, err)
+			}
+		}
+	}
+
+	// The unmodified file is accepted in strict mode.
+	reader := NewReader(bytes.NewReader(data))
+	reader.DecodeMode = DecodeStrict
+	for i := 0; i < 17; i++ {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reader = NewReader(bytes.NewReader(padded))
+	reader.DecodeMode = DecodeStrict
+	for i := 0; i < 2; i++ {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := reader.Next(); !errors.Is(err, DecodingError) {
+		t.Fatalf("expected DecodingError, got %v", err)
+	}
+}
+
+func TestRecordReaderReadRecords(t *testing.T) {
+	reader, err := NewRecordReader("test/multi-record-event.log", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	records, err := reader.ReadRecords(10)
+	if err != nil || len(records) != 10 {
+		t.Fatalf("expected 10 records, got %d, %v", len(records), err)
+	}
+	if records[0].SourceName != "test/multi-record-event.log" {
+		t.Fatalf("unexpected source name %q", records[0].SourceName)
+	}
+	records, err = reader.ReadRecords(10)
+	if !atEOF(err) || len(records) != 7 {
+		t.Fatalf("expected 7 records and the end of file, got %d, %v",
+			len(records), err)
+	}
+}
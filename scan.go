@@ -0,0 +1,528 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// SkipRecord reads the header of the next record and seeks past its
+// body, returning the header and the offset of the record.
+//
+// Like ReadRawRecord, if the record is incomplete or has an unknown
+// type the file offset is reset and ErrBufferTooSmall or
+// ErrInvalidHeader is returned.
+func SkipRecord(file io.ReadSeeker) (RawHeader, int64, error) {
+	var header RawHeader
+
+	offset, err := file.Seek(0, 1)
+	if err != nil {
+		return header, offset, err
+	}
+
+	var buf [rawHeaderLen]byte
+	n, err := io.ReadFull(file, buf[:])
+	if err != nil {
+		file.Seek(offset, 0)
+		return header, offset, &ErrBufferTooSmall{int64(rawHeaderLen - n)}
+	}
+	header.Type = binary.BigEndian.Uint32(buf[0:4])
+	header.Len = binary.BigEndian.Uint32(buf[4:8])
+
+	if !validRecordType(header.Type) {
+		file.Seek(offset, 0)
+		return header, offset, fmt.Errorf("%w: Unknown record type", ErrInvalidHeader)
+	}
+
+	end := offset + rawHeaderLen + int64(header.Len)
+	if header.Len > 0 {
+		// Make sure the last byte of the record exists.
+		if _, err := file.Seek(end-1, 0); err != nil {
+			file.Seek(offset, 0)
+			return header, offset, err
+		}
+		if _, err := io.ReadFull(file, buf[0:1]); err != nil {
+			size, _ := file.Seek(0, 2)
+			file.Seek(offset, 0)
+			return header, offset, &ErrBufferTooSmall{end - size}
+		}
+	}
+
+	return header, offset, nil
+}
+
+// readPrefix reads the first n bytes of the body of the record with
+// the provided header at offset, as returned by SkipRecord, leaving
+// the file positioned at the end of the record.
+func readPrefix(file io.ReadSeeker, header RawHeader, offset int64, n int) ([]byte, error) {
+	end := offset + rawHeaderLen + int64(header.Len)
+
+	data := make([]byte, n)
+	if _, err := file.Seek(offset+rawHeaderLen, 0); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(file, data); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(end, 0); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// CountRecords counts the records from the current position to the
+// end of file.
+//
+// Only the record headers are read, the record bodies are skipped
+// over by seeking.  If the file ends with an incomplete record it is
+// not counted and ErrBufferTooSmall is returned along with the count
+// of complete records, leaving the file positioned at the start of
+// the incomplete record.
+func CountRecords(file io.ReadSeeker) (int, error) {
+	count := 0
+	for {
+		_, _, err := SkipRecord(file)
+		if err != nil {
+			if atEOF(err) {
+				return count, nil
+			}
+			return count, err
+		}
+		count++
+	}
+}
+
+// rawSensorId returns the SensorId of a raw record without decoding
+// the rest of the record.
+func rawSensorId(raw *RawRecord) (uint32, bool) {
+	offset := 0
+	if raw.Type == UNIFIED2_EXTRA_DATA {
+		// Skip the EventType and EventLength.
+		offset = 8
+	}
+	if len(raw.Data) < offset+4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(raw.Data[offset:]), true
+}
+
+// AssertSensors reads all the records from file and returns the
+// SensorIds found that are not in the allowed set, in the order they
+// were first seen.
+//
+// Records are not fully decoded, only the SensorId is read from each
+// record.  If the end of the file is reached on a record boundary the
+// error will be nil, otherwise the error encountered is returned
+// along with the unexpected SensorIds found so far.
+func AssertSensors(file io.ReadSeeker, allowed map[uint32]bool) ([]uint32, error) {
+	var unexpected []uint32
+	seen := map[uint32]bool{}
+
+	for {
+		raw, err := ReadRawRecord(file)
+		if err != nil {
+			if atEOF(err) {
+				return unexpected, nil
+			}
+			return unexpected, err
+		}
+
+		sensorId, ok := rawSensorId(raw)
+		if !ok {
+			return unexpected, DecodingError
+		}
+		if !allowed[sensorId] && !seen[sensorId] {
+			seen[sensorId] = true
+			unexpected = append(unexpected, sensorId)
+		}
+	}
+}
+
+// The maximum length of an unregistered leading header found by
+// SkipLeadingHeader.
+const maxLeadingHeaderLen = 64
+
+var leadingHeaders = struct {
+	sync.RWMutex
+	headers map[string]int
+}{headers: make(map[string]int)}
+
+// RegisterLeadingHeader registers a header prepended to unified2 files
+// by a tool, starting with magic and length bytes long including the
+// magic, to be recognized and skipped by SkipLeadingHeader.
+// Registering a length of 0 removes a previously registered header.
+func RegisterLeadingHeader(magic string, length int) {
+	leadingHeaders.Lock()
+	defer leadingHeaders.Unlock()
+	if length <= 0 {
+		delete(leadingHeaders.headers, magic)
+	} else {
+		if length < len(magic) {
+			length = len(magic)
+		}
+		leadingHeaders.headers[magic] = length
+	}
+}
+
+// leadingHeaderLen returns the length of the registered leading header
+// buf starts with, preferring the longest matching magic, or 0 if
+// none matches.
+func leadingHeaderLen(buf []byte) int {
+	leadingHeaders.RLock()
+	defer leadingHeaders.RUnlock()
+	var magic string
+	length := 0
+	for m, l := range leadingHeaders.headers {
+		if len(m) > len(magic) && bytes.HasPrefix(buf, []byte(m)) {
+			magic, length = m, l
+		}
+	}
+	return length
+}
+
+// maxMagicLen returns the length of the longest registered magic.
+func maxMagicLen() int {
+	leadingHeaders.RLock()
+	defer leadingHeaders.RUnlock()
+	n := 0
+	for magic := range leadingHeaders.headers {
+		if len(magic) > n {
+			n = len(magic)
+		}
+	}
+	return n
+}
+
+// isRecordAt returns true if a complete record of a known type starts
+// at offset and is followed by either the end of the file or another
+// record header of a known type.
+func isRecordAt(file io.ReadSeeker, offset int64) bool {
+	if _, err := file.Seek(offset, 0); err != nil {
+		return false
+	}
+	if _, _, err := SkipRecord(file); err != nil {
+		return false
+	}
+	var buf [rawHeaderLen]byte
+	n, _ := io.ReadFull(file, buf[:])
+	if n == 0 {
+		return true
+	}
+	return n == rawHeaderLen && validRecordType(binary.BigEndian.Uint32(buf[0:4]))
+}
+
+// SkipLeadingHeader detects a header prepended to the unified2 data
+// by some tools, and advances the file past it, returning the number
+// of bytes skipped.
+//
+// Headers are recognized by the magic they start with, as registered
+// with RegisterLeadingHeader, and skipped by their registered length.
+// No headers are registered by default, as none are part of the
+// unified2 format.  The registered magics are checked first, so a
+// header is skipped even if it happens to start like a record.
+//
+// As a fallback for unregistered headers, if the data does not start
+// with a record header of a known type, the first 64 bytes are
+// searched for the start of a complete record that is followed by the
+// end of the file or another valid record header.  This may be fooled
+// by corrupt data, so registering the magic of a known header is
+// preferred.
+//
+// If no header needs to be skipped 0 is returned and the file position
+// is unchanged.  If the data does not start with a record and none
+// could be found, ErrInvalidHeader is returned and the file position
+// is unchanged.
+func SkipLeadingHeader(file io.ReadSeeker) (int, error) {
+	offset, err := file.Seek(0, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	size := maxMagicLen()
+	if size < rawHeaderLen {
+		size = rawHeaderLen
+	}
+	buf := make([]byte, size)
+	n, _ := io.ReadFull(file, buf)
+	buf = buf[:n]
+
+	if skip := leadingHeaderLen(buf); skip > 0 {
+		if _, err := file.Seek(offset+int64(skip), 0); err != nil {
+			file.Seek(offset, 0)
+			return 0, err
+		}
+		return skip, nil
+	}
+
+	if n < 4 || validRecordType(binary.BigEndian.Uint32(buf[0:4])) {
+		file.Seek(offset, 0)
+		return 0, nil
+	}
+
+	for skip := 1; skip <= maxLeadingHeaderLen; skip++ {
+		if isRecordAt(file, offset+int64(skip)) {
+			file.Seek(offset+int64(skip), 0)
+			return skip, nil
+		}
+	}
+
+	file.Seek(offset, 0)
+	return 0, fmt.Errorf("%w: No record found after leading header", ErrInvalidHeader)
+}
+
+// The number of bytes read at a time when resyncing.
+const resyncChunkLen = 64 * 1024
+
+// Resync scans file forward, starting at the byte after the current
+// position, for the next plausible record so the rest of a file can be
+// read after corrupt data, such as when ReadRecord returns
+// ErrInvalidHeader.
+//
+// A plausible record has a header of a known type with a length
+// between the minimum for the type and DefaultMaxRecordLen, is complete
+// and is followed by the end of the file or another valid record
+// header.  The file is positioned at the record found and the number
+// of bytes skipped returned.  If no record is found ErrInvalidHeader is
+// returned and the file position is unchanged.
+func Resync(file io.ReadSeeker) (int64, error) {
+	return resync(file, DefaultMaxRecordLen)
+}
+
+func resync(file io.ReadSeeker, maxLen uint32) (int64, error) {
+	offset, err := file.Seek(0, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, resyncChunkLen)
+	start := offset + 1
+	for {
+		if _, err := file.Seek(start, 0); err != nil {
+			break
+		}
+		n, _ := io.ReadFull(file, buf)
+		for i := 0; i+rawHeaderLen <= n; i++ {
+			if plausibleHeader(buf[i:], maxLen) && isRecordAt(file, start+int64(i)) {
+				file.Seek(start+int64(i), 0)
+				return start + int64(i) - offset, nil
+			}
+		}
+		if n < len(buf) {
+			break
+		}
+
+		// Overlap the chunks so headers spanning them are found.
+		start += int64(n - rawHeaderLen + 1)
+	}
+
+	file.Seek(offset, 0)
+	return 0, fmt.Errorf("%w: No record found while resyncing", ErrInvalidHeader)
+}
+
+// plausibleHeader reports whether buf starts with a header of a known
+// record type with a length between the minimum for the type and
+// maxLen.
+func plausibleHeader(buf []byte, maxLen uint32) bool {
+	recordType := binary.BigEndian.Uint32(buf[0:4])
+	length := binary.BigEndian.Uint32(buf[4:8])
+	return validRecordType(recordType) && length <= maxLen &&
+		int64(length) >= int64(minRecordLen(recordType))
+}
+
+// fingerprint returns a hash of the type and data of a raw record.
+func fingerprint(raw *RawRecord) [32]byte {
+	hash := sha256.New()
+	binary.Write(hash, binary.BigEndian, raw.Type)
+	hash.Write(raw.Data)
+	var sum [32]byte
+	copy(sum[:], hash.Sum(nil))
+	return sum
+}
+
+// OverlapWindow is the number of records at the end of file a that
+// Overlap compares against the start of file b, and so the longest
+// overlap it can find.
+var OverlapWindow = 1024
+
+// readFingerprints returns the fingerprints of up to max records read
+// from file.
+func readFingerprints(file io.ReadSeeker, max int) ([][32]byte, error) {
+	var fingerprints [][32]byte
+	for len(fingerprints) < max {
+		raw, err := ReadRawRecord(file)
+		if err != nil {
+			if atEOF(err) {
+				break
+			}
+			return nil, err
+		}
+		fingerprints = append(fingerprints, fingerprint(raw))
+	}
+	return fingerprints, nil
+}
+
+// readTailFingerprints returns the fingerprints of the last max
+// records read from file, in order, keeping only those in a ring
+// buffer.
+func readTailFingerprints(file io.ReadSeeker, max int) ([][32]byte, error) {
+	ring := make([][32]byte, 0, max)
+	next := 0
+	for {
+		raw, err := ReadRawRecord(file)
+		if err != nil {
+			if atEOF(err) {
+				break
+			}
+			return nil, err
+		}
+		if len(ring) < max {
+			ring = append(ring, fingerprint(raw))
+		} else {
+			ring[next] = fingerprint(raw)
+			next = (next + 1) % max
+		}
+	}
+	return append(ring[next:], ring[:next]...), nil
+}
+
+// Overlap returns the number of records at the start of b that are
+// duplicates of the records at the end of a, as can happen when a
+// producer restarts and re-logs records into a new spool file.
+//
+// Records are compared by a hash of their type and data.  Both files
+// are read from their current position to the end of file a and as
+// far as needed into b.  Only the last OverlapWindow records of a are
+// kept, so a longer overlap is not found.
+func Overlap(a, b io.ReadSeeker) (int, error) {
+	if OverlapWindow <= 0 {
+		return 0, nil
+	}
+	tail, err := readTailFingerprints(a, OverlapWindow)
+	if err != nil {
+		return 0, err
+	}
+	head, err := readFingerprints(b, len(tail))
+	if err != nil {
+		return 0, err
+	}
+	if len(head) == 0 {
+		return 0, nil
+	}
+
+	// The prefix function of head: prefix[i] is the length of the
+	// longest proper prefix of head[:i+1] that is also a suffix of it.
+	prefix := make([]int, len(head))
+	for i, k := 1, 0; i < len(head); i++ {
+		for k > 0 && head[i] != head[k] {
+			k = prefix[k-1]
+		}
+		if head[i] == head[k] {
+			k++
+		}
+		prefix[i] = k
+	}
+
+	// Match tail against head, n being the length of the longest
+	// prefix of head that is a suffix of the tail matched so far.
+	n := 0
+	for _, fp := range tail {
+		for n > 0 && (n == len(head) || head[n] != fp) {
+			n = prefix[n-1]
+		}
+		if head[n] == fp {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// ErrNoEvent is returned when a file does not contain an event record.
+var ErrNoEvent = errors.New("Unified2 event record not found")
+
+// isEventType returns true if recordType is one of the event types.
+func isEventType(recordType uint32) bool {
+	switch recordType {
+	case UNIFIED2_EVENT,
+		UNIFIED2_EVENT_IP6,
+		UNIFIED2_EVENT_V2,
+		UNIFIED2_EVENT_V2_IP6,
+		UNIFIED2_EVENT_APPID,
+		UNIFIED2_EVENT_APPID_IP6:
+		return true
+	}
+	return false
+}
+
+// NewestEventAge returns the age of the last event record in file,
+// useful for detecting a spool that is no longer being written to.
+//
+// The file is scanned from the current position by reading only the
+// record headers, then only the last event is decoded.  An incomplete
+// record at the end of the file is ignored.  ErrNoEvent is returned if
+// the file does not contain an event.
+func NewestEventAge(file io.ReadSeeker) (time.Duration, error) {
+	lastEvent := int64(-1)
+	for {
+		header, offset, err := SkipRecord(file)
+		if err != nil {
+			e := &ErrBufferTooSmall{}
+			if errors.As(err, &e) {
+				break
+			}
+			return 0, err
+		}
+		if isEventType(header.Type) {
+			lastEvent = offset
+		}
+	}
+
+	if lastEvent < 0 {
+		return 0, ErrNoEvent
+	}
+
+	if _, err := file.Seek(lastEvent, 0); err != nil {
+		return 0, err
+	}
+	raw, err := ReadRawRecord(file)
+	if err != nil {
+		return 0, err
+	}
+	event, err := DecodeEventRecord(raw.Type, raw.Data)
+	if err != nil {
+		return 0, err
+	}
+
+	return event.Age(), nil
+}
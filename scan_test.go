@@ -0,0 +1,314 @@
+package unified2
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAssertSensors(t *testing.T) {
+	input, err := os.Open("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	// All records in the test file are from sensor 0.
+	unexpected, err := AssertSensors(input, map[uint32]bool{0: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unexpected) != 0 {
+		t.Fatalf("unexpected sensors: %v", unexpected)
+	}
+
+	input.Seek(0, 0)
+	unexpected, err = AssertSensors(input, map[uint32]bool{1: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(unexpected, []uint32{0}) {
+		t.Fatalf("unexpected sensors: %v", unexpected)
+	}
+}
+
+func TestCountRecords(t *testing.T) {
+	input, err := os.Open("test/multi-record-event-x2.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	count, err := CountRecords(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 34 {
+		t.Fatalf("expected 34 records, got %d", count)
+	}
+
+	// A partial record should not be counted.
+	input, err = os.Open("test/short-read-on-body.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	count, err = CountRecords(input)
+	if e := (&ErrBufferTooSmall{}); !errors.As(err, &e) || e.MissingBytes != 56 {
+		t.Fatalf("expected ErrBufferTooSmall with MissingBytes == 56, got %v", err)
+	}
+	if count != 0 {
+		t.Fatalf("expected 0 records, got %d", count)
+	}
+	offset, _ := input.Seek(0, 1)
+	if offset != 0 {
+		t.Fatalf("expected file offset to be at 0, was at %d", offset)
+	}
+}
+
+func TestSkipLeadingHeader(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No leading header.
+	input := bytes.NewReader(buf)
+	skipped, err := SkipLeadingHeader(input)
+	if err != nil || skipped != 0 {
+		t.Fatalf("expected nothing to be skipped, got %d, err=%v", skipped, err)
+	}
+
+	header := []byte("U2WRAP\x01\x00\x00\x00\x00\x10")
+	input = bytes.NewReader(append(header, buf...))
+	skipped, err = SkipLeadingHeader(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != len(header) {
+		t.Fatalf("expected %d bytes to be skipped, got %d", len(header), skipped)
+	}
+	count, err := CountRecords(input)
+	if err != nil || count != 17 {
+		t.Fatalf("expected 17 records, got %d, err=%v", count, err)
+	}
+
+	input = bytes.NewReader(bytes.Repeat([]byte{0xff}, 100))
+	if _, err := SkipLeadingHeader(input); !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf("expected ErrInvalidHeader, got %v", err)
+	}
+
+	// A registered header is skipped by its length, even if it
+	// starts like a record.
+	RegisterLeadingHeader("\x00\x00\x00\x02WRAP", 16)
+	defer RegisterLeadingHeader("\x00\x00\x00\x02WRAP", 0)
+	header = append([]byte("\x00\x00\x00\x02WRAP"), make([]byte, 8)...)
+	input = bytes.NewReader(append(header, buf...))
+	skipped, err = SkipLeadingHeader(input)
+	if err != nil || skipped != 16 {
+		t.Fatalf("expected 16 bytes to be skipped, got %d, err=%v", skipped, err)
+	}
+	if count, err := CountRecords(input); err != nil || count != 17 {
+		t.Fatalf("expected 17 records, got %d, err=%v", count, err)
+	}
+}
+
+func TestOverlap(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The last 5 records of a are the first 5 of b: the first record
+	// of a is 68 bytes, and the last 5 records start at offset 31200.
+	a := bytes.NewReader(buf)
+	b := bytes.NewReader(append(append([]byte{}, buf[31200:]...), buf[:68]...))
+	n, err := Overlap(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Fatalf("expected overlap of 5 records, got %d", n)
+	}
+
+	a = bytes.NewReader(buf)
+	b = bytes.NewReader(buf[:68])
+	n, err = Overlap(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("expected no overlap, got %d", n)
+	}
+}
+
+// Repeated records at the end of a are matched against the start of b
+// as the longest suffix of a that is a prefix of b.
+func TestOverlapRepeated(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records [][]byte
+	input := bytes.NewReader(buf)
+	for offset := int64(0); offset < int64(len(buf)); {
+		if _, err := ReadRawRecord(input); err != nil {
+			t.Fatal(err)
+		}
+		end, _ := input.Seek(0, 1)
+		records = append(records, buf[offset:end])
+		offset = end
+	}
+	join := func(indexes ...int) *bytes.Reader {
+		var data []byte
+		for _, i := range indexes {
+			data = append(data, records[i]...)
+		}
+		return bytes.NewReader(data)
+	}
+
+	n, err := Overlap(join(1, 2, 1, 2, 1), join(1, 2, 1, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("expected overlap of 3 records, got %d", n)
+	}
+
+	// Only the longest overlap within the window is found.
+	defer func(window int) { OverlapWindow = window }(OverlapWindow)
+	OverlapWindow = 2
+	n, err = Overlap(join(1, 2, 1, 2, 1), join(1, 2, 1, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected overlap of 1 record, got %d", n)
+	}
+	n, err = Overlap(join(3, 4, 1, 2), join(1, 2, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expected overlap of 2 records, got %d", n)
+	}
+}
+
+func TestNewestEventAge(t *testing.T) {
+	input, err := os.Open("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	// The event in the test file is from July 2000.
+	age, err := NewestEventAge(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := time.Since(time.Unix(964798804, 267362000))
+	if age < expected-time.Minute || age > expected+time.Minute {
+		t.Fatalf("expected age of about %s, got %s", expected, age)
+	}
+
+	// Skip the event, only packets and extra data remain.
+	input.Seek(68, 0)
+	if _, err := NewestEventAge(input); err != ErrNoEvent {
+		t.Fatalf("expected ErrNoEvent, got %v", err)
+	}
+}
+
+func TestSkipRecord(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut the file in the middle of the third record.
+	file := bytes.NewReader(buf[:18800])
+
+	expected := []struct {
+		recordType uint32
+		offset     int64
+	}{
+		{UNIFIED2_EVENT_V2, 0},
+		{UNIFIED2_EXTRA_DATA, 68},
+	}
+	for _, e := range expected {
+		header, offset, err := SkipRecord(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Type != e.recordType || offset != e.offset {
+			t.Fatalf("expected type %d at %d, got type %d at %d",
+				e.recordType, e.offset, header.Type, offset)
+		}
+		if next, _ := file.Seek(0, 1); next != offset+rawHeaderLen+int64(header.Len) {
+			t.Fatalf("unexpected offset %d after record at %d", next, offset)
+		}
+	}
+
+	_, _, err = SkipRecord(file)
+	e := &ErrBufferTooSmall{}
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrBufferTooSmall, got %v", err)
+	}
+	if offset, _ := file.Seek(0, 1); offset != 18678 {
+		t.Fatalf("expected offset 18678, got %d", offset)
+	}
+}
+
+func TestResync(t *testing.T) {
+	data, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbage := bytes.Repeat([]byte{0xff}, 13)
+	file := bytes.NewReader(concat(data[:68], garbage, data[68:]))
+
+	if _, err := ReadRecord(file); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadRecord(file); !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf("expected ErrInvalidHeader, got %v", err)
+	}
+	skipped, err := Resync(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 13 {
+		t.Fatalf("expected 13 bytes skipped, got %d", skipped)
+	}
+	record, err := ReadRecord(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := record.(*ExtraDataRecord); !ok {
+		t.Fatalf("expected an extra data record, got %T", record)
+	}
+
+	// Without a following record the position is unchanged.
+	file = bytes.NewReader(bytes.Repeat([]byte{0xff}, resyncChunkLen+100))
+	file.Seek(10, 0)
+	if _, err := Resync(file); !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf("expected ErrInvalidHeader, got %v", err)
+	}
+	if offset, _ := file.Seek(0, 1); offset != 10 {
+		t.Fatalf("expected offset 10, got %d", offset)
+	}
+
+	// A record spanning the chunks read is found.
+	file = bytes.NewReader(concat(make([]byte, resyncChunkLen-3), data))
+	skipped, err = Resync(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != resyncChunkLen-3 {
+		t.Fatalf("expected %d bytes skipped, got %d", resyncChunkLen-3, skipped)
+	}
+}
@@ -0,0 +1,101 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"io"
+)
+
+// RecordScanner reads and decodes records from an io.ReadSeeker one at
+// a time, in the style of bufio.Scanner:
+//
+//	scanner := unified2.NewRecordScanner(file)
+//	for scanner.Scan() {
+//		use(scanner.Record())
+//	}
+//	if err := scanner.Err(); err != nil {
+//		...
+//	}
+//
+// RecordScanner should be created with NewRecordScanner().
+type RecordScanner struct {
+	file   io.ReadSeeker
+	record *RecordContainer
+	err    error
+	done   bool
+}
+
+// NewRecordScanner creates a new RecordScanner reading from the
+// current position of file.
+func NewRecordScanner(file io.ReadSeeker) *RecordScanner {
+	return &RecordScanner{file: file}
+}
+
+// Scan reads the next record, which is then available from Record.
+//
+// Scan returns false when the end of the file is reached on a record
+// boundary, in which case Err returns nil, or when an error occurs,
+// which is returned by Err.  An incomplete record at the end of the
+// file is an ErrBufferTooSmall error, with the file offset left at the
+// start of the incomplete record as by ReadRawRecord.  Once Scan has
+// returned false all further calls return false.
+func (s *RecordScanner) Scan() bool {
+	if s.done {
+		return false
+	}
+
+	var err error
+	s.record, err = readRecord(s.file)
+	if err != nil {
+		if !atEOF(err) {
+			s.err = err
+		}
+		s.done = true
+		return false
+	}
+	return true
+}
+
+// Record returns the record read by the last call to Scan, or nil if
+// Scan returned false.
+func (s *RecordScanner) Record() *RecordContainer {
+	return s.record
+}
+
+// Err returns the error that stopped Scan, or nil if the end of the
+// file was reached on a record boundary.
+func (s *RecordScanner) Err() error {
+	return s.err
+}
+
+// Close closes the file, if it is an io.Closer.
+func (s *RecordScanner) Close() error {
+	if closer, ok := s.file.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package unified2
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestRecordScanner(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := NewRecordScanner(bytes.NewReader(buf))
+	count := 0
+	for scanner.Scan() {
+		if scanner.Record() == nil {
+			t.Fatal("unexpected nil record")
+		}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 17 {
+		t.Fatalf("expected 17 records, got %d", count)
+	}
+	if scanner.Scan() || scanner.Record() != nil {
+		t.Fatal("expected Scan to return false after end of file")
+	}
+}
+
+func TestRecordScannerIncomplete(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Cut the file in the middle of the extra data record.
+	file := bytes.NewReader(buf[:100])
+	scanner := NewRecordScanner(file)
+	count := 0
+	for scanner.Scan() {
+		count++
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 record, got %d", count)
+	}
+	e := &ErrBufferTooSmall{}
+	if !errors.As(scanner.Err(), &e) {
+		t.Fatalf("expected ErrBufferTooSmall, got %v", scanner.Err())
+	}
+	if offset, _ := file.Seek(0, 1); offset != 68 {
+		t.Fatalf("expected offset 68, got %d", offset)
+	}
+}
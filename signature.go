@@ -0,0 +1,209 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"sort"
+	"time"
+)
+
+// SignatureKey identifies a rule by generator and signature id.
+type SignatureKey struct {
+	GeneratorId uint32
+	SignatureId uint32
+}
+
+// SignatureKey returns the generator and signature id of the event.
+func (e *EventRecord) SignatureKey() SignatureKey {
+	return SignatureKey{e.GeneratorId, e.SignatureId}
+}
+
+// SigCount is the number of events observed for a signature.
+//
+// Once more signatures have been observed than a SignatureRate
+// tracks, Count may overestimate the true count by at most Error.
+type SigCount struct {
+	SignatureKey
+	Count uint64
+	Error uint64
+}
+
+// DefaultSignatureRateCapacity is the number of signatures tracked by
+// a SignatureRate created with a capacity of 0.
+const DefaultSignatureRateCapacity = 1000
+
+// SignatureRate counts events by signature to find the busiest rules.
+//
+// Memory is bounded by the capacity.  Up to capacity signatures are
+// counted exactly, beyond that the least frequent signature is
+// replaced by the new one (the Space-Saving algorithm), so the
+// busiest signatures are still reported, with counts accurate to
+// within the Error of each SigCount.
+type SignatureRate struct {
+	capacity int
+	counts   map[SignatureKey]*SigCount
+}
+
+// NewSignatureRate creates a SignatureRate tracking up to capacity
+// signatures.
+func NewSignatureRate(capacity int) *SignatureRate {
+	if capacity <= 0 {
+		capacity = DefaultSignatureRateCapacity
+	}
+	return &SignatureRate{
+		capacity: capacity,
+		counts:   make(map[SignatureKey]*SigCount),
+	}
+}
+
+// Observe counts an event.
+func (r *SignatureRate) Observe(e *EventRecord) {
+	key := e.SignatureKey()
+
+	if count, ok := r.counts[key]; ok {
+		count.Count++
+		return
+	}
+
+	if len(r.counts) < r.capacity {
+		r.counts[key] = &SigCount{SignatureKey: key, Count: 1}
+		return
+	}
+
+	var min *SigCount
+	for _, count := range r.counts {
+		if min == nil || count.Count < min.Count {
+			min = count
+		}
+	}
+	delete(r.counts, min.SignatureKey)
+	r.counts[key] = &SigCount{
+		SignatureKey: key,
+		Count:        min.Count + 1,
+		Error:        min.Count,
+	}
+}
+
+// TopN returns up to n signatures with the most events, busiest
+// first.  If n is 0 or less all signatures are returned.
+func (r *SignatureRate) TopN(n int) []SigCount {
+	counts := make([]SigCount, 0, len(r.counts))
+	for _, count := range r.counts {
+		counts = append(counts, *count)
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.GeneratorId != b.GeneratorId {
+			return a.GeneratorId < b.GeneratorId
+		}
+		return a.SignatureId < b.SignatureId
+	})
+
+	if n > 0 && n < len(counts) {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// SignatureWindow is the busiest signatures within a window of time.
+type SignatureWindow struct {
+	Start time.Time
+	Top   []SigCount
+}
+
+// WindowedSignatureRate counts events by signature in windows of
+// event time, such as a minute, to find bursts of events.
+//
+// Only the most recent windows are kept, events older than the kept
+// windows are ignored.
+type WindowedSignatureRate struct {
+	window   time.Duration
+	windows  int
+	capacity int
+	rates    map[time.Time]*SignatureRate
+}
+
+// NewWindowedSignatureRate creates a WindowedSignatureRate with
+// windows of the given duration, keeping up to windows windows each
+// tracking up to capacity signatures.  At least one window is kept.
+func NewWindowedSignatureRate(window time.Duration, windows, capacity int) *WindowedSignatureRate {
+	if windows < 1 {
+		windows = 1
+	}
+	return &WindowedSignatureRate{
+		window:   window,
+		windows:  windows,
+		capacity: capacity,
+		rates:    make(map[time.Time]*SignatureRate),
+	}
+}
+
+// Observe counts an event in the window of its timestamp.
+func (w *WindowedSignatureRate) Observe(e *EventRecord) {
+	start := e.Timestamp().Truncate(w.window)
+
+	rate, ok := w.rates[start]
+	if !ok {
+		starts := w.starts()
+		if len(starts) >= w.windows && !start.After(starts[0]) {
+			return
+		}
+		rate = NewSignatureRate(w.capacity)
+		w.rates[start] = rate
+		if len(starts) >= w.windows {
+			delete(w.rates, starts[0])
+		}
+	}
+	rate.Observe(e)
+}
+
+// TopN returns the up to n busiest signatures of each window, oldest
+// window first.  If n is 0 or less all signatures are returned.
+func (w *WindowedSignatureRate) TopN(n int) []SignatureWindow {
+	starts := w.starts()
+	windows := make([]SignatureWindow, len(starts))
+	for i, start := range starts {
+		windows[i] = SignatureWindow{start, w.rates[start].TopN(n)}
+	}
+	return windows
+}
+
+// starts returns the start times of the windows, oldest first.
+func (w *WindowedSignatureRate) starts() []time.Time {
+	starts := make([]time.Time, 0, len(w.rates))
+	for start := range w.rates {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i].Before(starts[j])
+	})
+	return starts
+}
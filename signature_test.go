@@ -0,0 +1,53 @@
+package unified2
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSignatureRate(t *testing.T) {
+	rate := NewSignatureRate(2)
+	for _, sid := range []uint32{1, 1, 1, 2, 2, 3} {
+		rate.Observe(&EventRecord{GeneratorId: 1, SignatureId: sid})
+	}
+
+	// Signature 3 replaced signature 2 with its count of 2.
+	expected := []SigCount{
+		{SignatureKey{1, 1}, 3, 0},
+		{SignatureKey{1, 3}, 3, 2},
+	}
+	if top := rate.TopN(5); !reflect.DeepEqual(top, expected) {
+		t.Fatalf("expected %v, got %v", expected, top)
+	}
+	if top := rate.TopN(1); len(top) != 1 {
+		t.Fatalf("expected 1 signature, got %d", len(top))
+	}
+
+	// No limit.
+	for _, n := range []int{0, -1} {
+		if top := rate.TopN(n); !reflect.DeepEqual(top, expected) {
+			t.Fatalf("TopN(%d): expected %v, got %v", n, expected, top)
+		}
+	}
+}
+
+func TestWindowedSignatureRate(t *testing.T) {
+	rate := NewWindowedSignatureRate(time.Minute, 2, 10)
+	for _, second := range []uint32{0, 30, 60, 120, 150, 0} {
+		rate.Observe(&EventRecord{EventSecond: second, SignatureId: 1})
+	}
+
+	windows := rate.TopN(1)
+	if len(windows) != 2 {
+		t.Fatalf("expected 2 windows, got %d", len(windows))
+	}
+	if !windows[0].Start.Equal(time.Unix(60, 0)) ||
+		windows[0].Top[0].Count != 1 {
+		t.Fatalf("unexpected first window %v", windows[0])
+	}
+	if !windows[1].Start.Equal(time.Unix(120, 0)) ||
+		windows[1].Top[0].Count != 2 {
+		t.Fatalf("unexpected second window %v", windows[1])
+	}
+}
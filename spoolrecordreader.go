@@ -27,12 +27,16 @@
 package unified2
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // SpoolRecordReader is a unified2 record reader that reads from a
@@ -42,16 +46,69 @@ import (
 // suffixed by a timestamp.  This is the typical format used by Snort
 // and Suricata as new unified2 files are closed and a new one is
 // created when they reach a certain size.
+//
+// Files are read in timestamp order, advancing to the next file once
+// the current file has been read and a newer file exists.  At the end
+// of the newest file Next returns an ErrBufferTooSmall error with
+// MissingBytes of 8, the caller can then poll by calling Next again
+// later, or use NextContext which waits for more records.  The
+// position given by Offset may be saved and passed to SeekTo to resume
+// reading where it left off.
 type SpoolRecordReader struct {
 
 	// CloseHook will be called when a file is closed.  It can be used
 	// to delete or archive the file.
 	CloseHook func(string)
 
+	// ArchivePolicy is applied to a file once it has been read and the
+	// reader has moved on to the next file, after calling the
+	// CloseHook.  Errors archiving a file are logged.
+	ArchivePolicy ArchivePolicy
+
+	// ArchiveDirectory is the directory files are moved or compressed
+	// into.  If empty compressed files are written to the spool
+	// directory, where they are skipped by the reader.
+	ArchiveDirectory string
+
+	// MaxRecordsPerSecond, if greater than 0, limits the rate at which
+	// Next returns records.  Next sleeps as needed to stay within the
+	// limit while allowing bursts of up to Burst records.
+	//
+	// Next can not be cancelled, so while rate limited a call to Next
+	// may block for up to 1/MaxRecordsPerSecond seconds before
+	// returning, NextContext stops waiting once its context is
+	// cancelled.
+	MaxRecordsPerSecond float64
+
+	// Burst is the number of records that may be returned without
+	// delay when rate limited.  Values less than 1 are treated as 1.
+	Burst int
+
+	// ReadTimeout, if greater than 0, limits how long NextContext
+	// waits for a record to be written to the spool, returning
+	// ErrReadTimeout if none is available in time, so callers may do
+	// other work between waits.  Time spent rate limited is not
+	// included.
+	ReadTimeout time.Duration
+
 	directory string
 	prefix    string
 	logger    *log.Logger
 	reader    *RecordReader
+
+	// The bookmark file of Resume and Commit, and files read but not
+	// yet committed.
+	bookmarkFile string
+	uncommitted  []string
+
+	// Rate limiting state.
+	tokens   float64
+	lastTime time.Time
+
+	// The counters shared by the readers of each file.
+	stats readerStats
+
+	closed bool
 }
 
 // NewSpoolRecordReader creates a new RecordSpoolReader reading files
@@ -86,13 +143,47 @@ func (r *SpoolRecordReader) getFiles() ([]os.FileInfo, error) {
 	filtered_idx := 0
 
 	for _, file := range files {
-		if strings.HasPrefix(file.Name(), r.prefix) {
+		// Compressed files, as archived by ArchiveCompress, can not
+		// be read, and hidden files are temporary files being
+		// written.
+		if strings.HasPrefix(file.Name(), r.prefix) &&
+			!strings.HasSuffix(file.Name(), ".gz") &&
+			(!strings.HasPrefix(file.Name(), ".") || strings.HasPrefix(r.prefix, ".")) {
 			filtered[filtered_idx] = file
 			filtered_idx++
 		}
 	}
+	filtered = filtered[0:filtered_idx]
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return r.spoolFileLess(filtered[i].Name(), filtered[j].Name())
+	})
 
-	return filtered[0:filtered_idx], nil
+	return filtered, nil
+}
+
+// spoolFileLess reports whether spool file a comes before b.  Files are
+// ordered by the timestamp following the prefix, compared as numbers
+// so that timestamps of different lengths are in order.  Files without
+// a numeric suffix are ordered after those with one, by name.
+func (r *SpoolRecordReader) spoolFileLess(a, b string) bool {
+	ta, errA := r.spoolTimestamp(a)
+	tb, errB := r.spoolTimestamp(b)
+	switch {
+	case errA == nil && errB == nil && ta != tb:
+		return ta < tb
+	case errA == nil && errB != nil:
+		return true
+	case errA != nil && errB == nil:
+		return false
+	}
+	return a < b
+}
+
+// spoolTimestamp returns the timestamp suffix of a spool file name.
+func (r *SpoolRecordReader) spoolTimestamp(filename string) (uint64, error) {
+	suffix := strings.TrimPrefix(strings.TrimPrefix(filename, r.prefix), ".")
+	return strconv.ParseUint(suffix, 10, 64)
 }
 
 // openNext opens the next available file if it exists.  If a new file
@@ -139,9 +230,12 @@ func (r *SpoolRecordReader) openNext() bool {
 		r.log("Closing %s.", r.reader.Name())
 		r.reader.Close()
 
-		// Call the close hook if set.
-		if r.CloseHook != nil {
-			r.CloseHook(r.reader.Name())
+		// With a bookmark the file is done with once a position past
+		// it has been committed.
+		if r.bookmarkFile != "" {
+			r.uncommitted = append(r.uncommitted, r.reader.Name())
+		} else {
+			r.done(r.reader.Name())
 		}
 	}
 
@@ -151,11 +245,84 @@ func (r *SpoolRecordReader) openNext() bool {
 		r.log("Failed to open %s: %s", nextFilename, err)
 		return false
 	}
+	r.reader.sharedStats = &r.stats
 	return true
 }
 
+// done calls the CloseHook and applies the ArchivePolicy to a file
+// that has been read.
+func (r *SpoolRecordReader) done(filename string) {
+	if r.CloseHook != nil {
+		r.CloseHook(filename)
+	}
+	r.archive(filename)
+}
+
+// archive applies the ArchivePolicy to filename.
+func (r *SpoolRecordReader) archive(filename string) {
+	directory := r.ArchiveDirectory
+	if directory == "" {
+		if r.ArchivePolicy == ArchiveMove {
+			r.log("No archive directory to move %s to.", filename)
+			return
+		}
+		directory = r.directory
+	}
+
+	if err := archiveFile(r.ArchivePolicy, filename, directory); err != nil {
+		r.log("Failed to archive %s: %s", filename, err)
+	}
+}
+
 // Next returns the next record read from the spool.
 func (r *SpoolRecordReader) Next() (interface{}, error) {
+	container, err := r.NextContainer()
+	if container == nil {
+		return nil, err
+	}
+	return container.Record, err
+}
+
+// NextContainer returns the next record read from the spool in a
+// RecordContainer, with the SourceName set to the path of the spool
+// file the record was read from.
+func (r *SpoolRecordReader) NextContainer() (*RecordContainer, error) {
+	return r.next(context.Background())
+}
+
+// NextContext returns the next record as NextContainer, but waits for
+// a record to be written if none is available, checking the spool for
+// more records every PollInterval.
+//
+// If ctx is cancelled while waiting ctx.Err() is returned, or if the
+// ReadTimeout expires first ErrReadTimeout.  If ctx is cancelled while
+// rate limited, the record already read is returned without further
+// delay.
+func (r *SpoolRecordReader) NextContext(ctx context.Context) (*RecordContainer, error) {
+	waitCtx, cancel := timeoutContext(ctx, r.ReadTimeout)
+	defer cancel()
+
+	for {
+		container, err := r.next(ctx)
+		e := &ErrBufferTooSmall{}
+		if container != nil || (err != nil && !errors.As(err, &e)) {
+			return container, err
+		}
+
+		if err := sleepContext(waitCtx, PollInterval); err != nil {
+			if err == context.DeadlineExceeded && ctx.Err() == nil {
+				return nil, ErrReadTimeout
+			}
+			return nil, err
+		}
+	}
+}
+
+// next returns the next record, rate limited as by throttle.
+func (r *SpoolRecordReader) next(ctx context.Context) (*RecordContainer, error) {
+	if r.closed {
+		return nil, os.ErrClosed
+	}
 
 	for {
 
@@ -169,7 +336,7 @@ func (r *SpoolRecordReader) Next() (interface{}, error) {
 			return nil, nil
 		}
 
-		record, err := r.reader.Next()
+		container, err := r.reader.NextContainer()
 
 		if e := (&ErrBufferTooSmall{}); errors.As(err, &e) && e.MissingBytes == 8 {
 			if r.openNext() {
@@ -177,10 +344,47 @@ func (r *SpoolRecordReader) Next() (interface{}, error) {
 			}
 		}
 
-		return record, err
+		if container != nil {
+			r.throttle(ctx)
+		}
+
+		return container, err
+
+	}
+
+}
+
+// throttle sleeps as needed to keep to MaxRecordsPerSecond, or until
+// ctx is cancelled.
+func (r *SpoolRecordReader) throttle(ctx context.Context) {
+	if r.MaxRecordsPerSecond <= 0 {
+		return
+	}
+
+	burst := float64(r.Burst)
+	if burst < 1 {
+		burst = 1
+	}
 
+	now := time.Now()
+	if r.lastTime.IsZero() {
+		r.tokens = burst
+	} else {
+		r.tokens += now.Sub(r.lastTime).Seconds() * r.MaxRecordsPerSecond
+		if r.tokens > burst {
+			r.tokens = burst
+		}
 	}
+	r.lastTime = now
 
+	if r.tokens < 1 {
+		wait := time.Duration((1 - r.tokens) / r.MaxRecordsPerSecond *
+			float64(time.Second))
+		sleepContext(ctx, wait)
+		r.lastTime = r.lastTime.Add(wait)
+		r.tokens = 1
+	}
+	r.tokens--
 }
 
 // Offset returns the current filename that is being processed and its
@@ -192,3 +396,102 @@ func (r *SpoolRecordReader) Offset() (string, int64) {
 		return "", 0
 	}
 }
+
+// Bookmark returns the current position of the reader as a Bookmark,
+// which may be saved and later passed to SeekTo to resume reading.
+func (r *SpoolRecordReader) Bookmark() *Bookmark {
+	filename, offset := r.Offset()
+	return &Bookmark{filename, offset}
+}
+
+// Resume resumes reading at the position saved in the bookmark file
+// filename by Commit, if it exists, and uses filename for following
+// calls to Commit.  A bookmark without a filename resumes reading from
+// the start of the spool.
+func (r *SpoolRecordReader) Resume(filename string) error {
+	bookmark, err := LoadBookmark(filename)
+	if err == nil {
+		if bookmark.Filename != "" {
+			err = r.SeekTo(bookmark.Filename, bookmark.Offset)
+		}
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	r.bookmarkFile = filename
+	return nil
+}
+
+// Commit saves the position after the records returned so far to the
+// bookmark file given to Resume.  It should be called once the records
+// have been durably handled, so a restarted reader resumes after the
+// last committed record, giving at-least-once delivery.
+//
+// While a bookmark file is in use the CloseHook and ArchivePolicy are
+// only applied to a file once a position past it has been committed.
+// Without an open file there is no position to commit, so the bookmark
+// file is left as is.
+func (r *SpoolRecordReader) Commit() error {
+	if r.bookmarkFile == "" {
+		return errors.New("no bookmark file to commit to")
+	}
+	if r.reader == nil {
+		return nil
+	}
+
+	if err := r.Bookmark().Save(r.bookmarkFile); err != nil {
+		return err
+	}
+
+	for _, filename := range r.uncommitted {
+		r.done(filename)
+	}
+	r.uncommitted = nil
+	return nil
+}
+
+// Stats returns the counters of the records read so far from all
+// files.  It may be called while another goroutine is reading.
+func (r *SpoolRecordReader) Stats() ReaderStats {
+	return r.stats.get()
+}
+
+// Close closes the currently open file, if any.  The CloseHook and
+// ArchivePolicy are not applied to it, as it may not have been read
+// completely.  Positions not yet committed are not saved, so that
+// records not yet handled are read again when resumed.  Once closed,
+// reading or seeking returns os.ErrClosed.
+func (r *SpoolRecordReader) Close() error {
+	r.closed = true
+	if r.reader == nil {
+		return nil
+	}
+	err := r.reader.Close()
+	r.reader = nil
+	return err
+}
+
+// SeekTo opens the spool file filename, as returned by Offset, and
+// positions the reader at offset so the next record read is the one at
+// offset.  The currently open file, if any, is closed without calling
+// the CloseHook.
+func (r *SpoolRecordReader) SeekTo(filename string, offset int64) error {
+	if r.closed {
+		return os.ErrClosed
+	}
+
+	reader, err := NewRecordReader(path.Join(r.directory, filename), offset)
+	if err != nil {
+		return err
+	}
+	reader.sharedStats = &r.stats
+
+	if r.reader != nil {
+		r.reader.Close()
+	}
+	r.reader = reader
+	return nil
+}
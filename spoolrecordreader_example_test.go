@@ -1,7 +1,7 @@
 package unified2_test
 
 import (
-	"io"
+	"errors"
 	"log"
 	"time"
 
@@ -16,12 +16,13 @@ func ExampleSpoolRecordReader() {
 	for {
 		record, err := reader.Next()
 		if err != nil {
-			if err == io.EOF {
-				// EOF is returned when the end of the last spool file
-				// is reached and there is nothing else to read.  For
-				// the purposes of the example, just sleep for a
-				// moment and try again.
+			if e := (&unified2.ErrBufferTooSmall{}); errors.As(err, &e) {
+				// ErrBufferTooSmall is returned when the end of the
+				// last spool file is reached and there is nothing
+				// else to read.  For the purposes of the example, just
+				// sleep for a moment and try again.
 				time.Sleep(time.Millisecond)
+				continue
 			} else {
 				// Unexpected error.
 				log.Fatal(err)
@@ -1,14 +1,17 @@
 package unified2
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 // Utility function to copy a file.
@@ -62,6 +65,37 @@ func TestRecordSpoolReader_getFiles(t *testing.T) {
 	}
 }
 
+// Spool files are ordered by timestamp, not by name.
+func TestRecordSpoolReaderFileOrder(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, name := range []string{"merged.log.1000", "merged.log.999",
+		"merged.log.old", "merged.log.10"} {
+		if err := ioutil.WriteFile(tmpdir+"/"+name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader := NewSpoolRecordReader(tmpdir, "merged.log")
+	files, err := reader.getFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	expected := []string{"merged.log.10", "merged.log.999", "merged.log.1000",
+		"merged.log.old"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+}
+
 // Basic test for RecordSpoolReader.
 func TestRecordSpoolReader(t *testing.T) {
 
@@ -172,3 +206,269 @@ func TestRecordSpoolReader(t *testing.T) {
 		t.Fatal("expected nil record")
 	}
 }
+
+func TestRecordSpoolReaderRateLimit(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	copyFile("test/multi-record-event-x2.log",
+		fmt.Sprintf("%s/merged.log.1382627900", tmpdir))
+
+	reader := NewSpoolRecordReader(tmpdir, "merged.log")
+	reader.MaxRecordsPerSecond = 1000
+	reader.Burst = 10
+
+	// The first 10 records are allowed as a burst, the remaining 20
+	// should take at least 20ms.
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		record, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record == nil {
+			t.Fatal("unexpected nil record")
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 19*time.Millisecond {
+		t.Fatalf("expected reading to be rate limited, took %s", elapsed)
+	}
+}
+
+func TestRecordSpoolReaderSeekTo(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	copyFile("test/multi-record-event.log",
+		fmt.Sprintf("%s/merged.log.1382627900", tmpdir))
+	copyFile("test/multi-record-event.log",
+		fmt.Sprintf("%s/merged.log.1382627901", tmpdir))
+
+	reader := NewSpoolRecordReader(tmpdir, "merged.log")
+	for i := 0; i < 20; i++ {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filename, offset := reader.Offset()
+	expected, err := reader.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new reader resumed at the saved position reads the same
+	// record, and continues through the rest of the spool.
+	resumed := NewSpoolRecordReader(tmpdir, "merged.log")
+	if err := resumed.SeekTo(filename, offset); err != nil {
+		t.Fatal(err)
+	}
+	record, err := resumed.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(record, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, record)
+	}
+	for i := 0; i < 13; i++ {
+		if _, err := resumed.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := resumed.Next(); !atEOF(err) {
+		t.Fatalf("expected the end of the spool, got %v", err)
+	}
+}
+
+func TestRecordSpoolReaderNextContext(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	defer func(interval time.Duration) {
+		PollInterval = interval
+	}(PollInterval)
+	PollInterval = time.Millisecond
+
+	copyFile("test/multi-record-event.log",
+		fmt.Sprintf("%s/merged.log.1382627900", tmpdir))
+
+	reader := NewSpoolRecordReader(tmpdir, "merged.log")
+	ctx := context.Background()
+	for i := 0; i < 17; i++ {
+		if _, err := reader.NextContext(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Waiting for more records is stopped by cancelling the context.
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := reader.NextContext(timeout); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// The ReadTimeout is reported distinctly from the context.
+	reader.ReadTimeout = 20 * time.Millisecond
+	if _, err := reader.NextContext(ctx); err != ErrReadTimeout {
+		t.Fatalf("expected ErrReadTimeout, got %v", err)
+	}
+	reader.ReadTimeout = time.Minute
+
+	// A file added to the spool while waiting is read.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		copyFile("test/multi-record-event.log",
+			fmt.Sprintf("%s/merged.log.1382627901", tmpdir))
+	}()
+	record, err := reader.NextContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := record.Record.(*EventRecord); !ok {
+		t.Fatalf("expected an event record, got %T", record.Record)
+	}
+}
+
+func TestRecordSpoolReaderArchivePolicy(t *testing.T) {
+	for _, policy := range []ArchivePolicy{ArchiveDelete, ArchiveMove, ArchiveCompress} {
+		tmpdir, err := ioutil.TempDir("", "unified2-test-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmpdir)
+		archive := tmpdir + "/archive"
+		if err := os.Mkdir(archive, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		copyFile("test/multi-record-event.log",
+			fmt.Sprintf("%s/merged.log.1382627900", tmpdir))
+		copyFile("test/multi-record-event.log",
+			fmt.Sprintf("%s/merged.log.1382627901", tmpdir))
+
+		reader := NewSpoolRecordReader(tmpdir, "merged.log")
+		reader.ArchivePolicy = policy
+		reader.ArchiveDirectory = archive
+		for i := 0; i < 18; i++ {
+			if _, err := reader.Next(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// The first file has been archived, the current one is left.
+		if _, err := os.Stat(tmpdir + "/merged.log.1382627900"); !os.IsNotExist(err) {
+			t.Fatalf("%s: expected the file to be removed, got %v", policy, err)
+		}
+		if _, err := os.Stat(tmpdir + "/merged.log.1382627901"); err != nil {
+			t.Fatalf("%s: %v", policy, err)
+		}
+
+		archived := archive + "/merged.log.1382627900"
+		switch policy {
+		case ArchiveDelete:
+			continue
+		case ArchiveCompress:
+			archived += ".gz"
+		}
+		file, err := OpenFile(archived)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		for {
+			if _, err := file.ReadRecord(); err != nil {
+				if err != io.EOF {
+					t.Fatal(err)
+				}
+				break
+			}
+			count++
+		}
+		file.Close()
+		if count != 17 {
+			t.Fatalf("%s: expected 17 records, got %d", policy, count)
+		}
+	}
+}
+
+func TestRecordSpoolReaderClose(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	copyFile("test/multi-record-event.log",
+		fmt.Sprintf("%s/merged.log.1382627900", tmpdir))
+
+	reader := NewSpoolRecordReader(tmpdir, "merged.log")
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	closed := false
+	reader.CloseHook = func(string) {
+		closed = true
+	}
+	if _, err := reader.Next(); err != os.ErrClosed {
+		t.Fatalf("expected os.ErrClosed, got %v", err)
+	}
+
+	reader = NewSpoolRecordReader(tmpdir, "merged.log")
+	reader.CloseHook = func(string) {
+		closed = true
+	}
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if closed {
+		t.Fatal("unexpected call of the CloseHook")
+	}
+	if filename, offset := reader.Offset(); filename != "" || offset != 0 {
+		t.Fatalf("unexpected offset %s:%d after Close", filename, offset)
+	}
+
+	// The spool is not read again once closed.
+	if _, err := reader.Next(); err != os.ErrClosed {
+		t.Fatalf("expected os.ErrClosed, got %v", err)
+	}
+	if _, err := reader.NextContext(context.Background()); err != os.ErrClosed {
+		t.Fatalf("expected os.ErrClosed, got %v", err)
+	}
+	if err := reader.SeekTo("merged.log.1382627900", 0); err != os.ErrClosed {
+		t.Fatalf("expected os.ErrClosed, got %v", err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Hidden files, such as the temporary files written when compressing,
+// are not read as spool files.
+func TestRecordSpoolReaderHiddenFiles(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	copyFile("test/multi-record-event.log",
+		fmt.Sprintf("%s/.merged.log.1382627900.gz.tmp", tmpdir))
+
+	reader := NewSpoolRecordReader(tmpdir, "")
+	defer reader.Close()
+	if record, err := reader.Next(); record != nil || err != nil {
+		t.Fatalf("expected no records, got %v, err=%v", record, err)
+	}
+}
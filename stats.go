@@ -0,0 +1,110 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"sync"
+)
+
+// ReaderStats holds counters of the records read by a reader, as
+// returned by the Stats method of the readers.
+type ReaderStats struct {
+	// Records is the number of records read by record type, including
+	// records that could not be decoded.
+	Records map[uint32]uint64
+
+	// Bytes is the number of bytes of the records read, including
+	// their headers.
+	Bytes uint64
+
+	// DecodeErrors is the number of records that could not be
+	// decoded, or were rejected by the DecodeMode or LengthPolicy.
+	DecodeErrors uint64
+
+	// UnknownRecords is the number of records of unknown types read,
+	// which are not included in Records.
+	UnknownRecords uint64
+
+	// SkippedRecords is the number of records skipped as they could
+	// not be decoded, see RecordReader.SkipCorrupt.
+	SkippedRecords uint64
+}
+
+// NumRecords returns the total number of records read, of all types.
+func (s ReaderStats) NumRecords() uint64 {
+	var count uint64
+	for _, n := range s.Records {
+		count += n
+	}
+	return count
+}
+
+// readerStats is the ReaderStats of a reader, which may be retrieved
+// while the reader is in use.  The zero value is ready to use.
+type readerStats struct {
+	sync.Mutex
+	stats ReaderStats
+}
+
+// record counts a record of recordType and length n, including its
+// header.
+func (s *readerStats) record(recordType uint32, n int64) {
+	s.Lock()
+	defer s.Unlock()
+	if validRecordType(recordType) {
+		if s.stats.Records == nil {
+			s.stats.Records = make(map[uint32]uint64)
+		}
+		s.stats.Records[recordType]++
+	} else {
+		s.stats.UnknownRecords++
+	}
+	s.stats.Bytes += uint64(n)
+}
+
+// decodeError counts a record that could not be decoded, and whether
+// it was skipped.
+func (s *readerStats) decodeError(skipped bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.stats.DecodeErrors++
+	if skipped {
+		s.stats.SkippedRecords++
+	}
+}
+
+// get returns a copy of the counters.
+func (s *readerStats) get() ReaderStats {
+	s.Lock()
+	defer s.Unlock()
+	stats := s.stats
+	stats.Records = make(map[uint32]uint64, len(s.stats.Records))
+	for recordType, n := range s.stats.Records {
+		stats.Records[recordType] = n
+	}
+	return stats
+}
@@ -0,0 +1,131 @@
+package unified2
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReaderStats(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := NewReader(bytes.NewReader(buf))
+	expected := ReaderStats{Records: make(map[uint32]uint64)}
+	for {
+		container, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		expected.Records[container.Type]++
+	}
+	expected.Bytes = uint64(len(buf))
+
+	stats := reader.Stats()
+	if !reflect.DeepEqual(stats, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, stats)
+	}
+	if stats.NumRecords() != 17 {
+		t.Fatalf("expected 17 records, got %d", stats.NumRecords())
+	}
+
+	// The returned stats are a copy.
+	stats.Records[UNIFIED2_PACKET] = 0
+	if reader.Stats().Records[UNIFIED2_PACKET] == 0 {
+		t.Fatal("stats modified through returned copy")
+	}
+}
+
+func TestRecordReaderStats(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := tmpdir + "/corrupt.log"
+	corrupt := corruptLog(t)
+	if err := ioutil.WriteFile(filename, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := NewRecordReader(filename, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	reader.SkipCorrupt = true
+	for {
+		_, err := reader.NextContainer()
+		if atEOF(err) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := reader.Stats()
+	if stats.DecodeErrors != 1 || stats.SkippedRecords != 1 {
+		t.Fatalf("expected 1 skipped decode error, got %+v", stats)
+	}
+	if stats.NumRecords() != 18 || stats.Bytes != uint64(len(corrupt)) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestSpoolRecordReaderStats(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"unified2.log.1", "unified2.log.2"} {
+		if err := ioutil.WriteFile(tmpdir+"/"+name, buf, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reader := NewSpoolRecordReader(tmpdir, "unified2.log")
+	defer reader.Close()
+	for {
+		record, err := reader.Next()
+		if atEOF(err) || record == nil {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := reader.Stats()
+	if stats.NumRecords() != 34 || stats.Bytes != 2*uint64(len(buf)) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+// A RecordReader created without NewRecordReader counts records too.
+func TestRecordReaderStatsLiteral(t *testing.T) {
+	file, err := os.Open("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := &RecordReader{File: file}
+	defer reader.Close()
+
+	if _, err := reader.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := reader.Stats(); stats.Records[UNIFIED2_EVENT_V2] != 1 || stats.Bytes != 68 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,126 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"io"
+	"sort"
+	"time"
+)
+
+// Store holds all the records of a file in memory, indexed for
+// querying by event.
+//
+// Stores should be created with LoadStore().
+type Store struct {
+	events    map[EventKey]*EventRecord
+	packets   map[EventKey][]*PacketRecord
+	extraData map[EventKey][]*ExtraDataRecord
+
+	// Events sorted by time.
+	chronological []*EventRecord
+}
+
+// LoadStore reads and decodes all the records from file into a new
+// Store.
+//
+// The file must end on a record boundary, any error reading or
+// decoding a record is returned.
+func LoadStore(file io.ReadSeeker) (*Store, error) {
+	store := &Store{
+		events:    make(map[EventKey]*EventRecord),
+		packets:   make(map[EventKey][]*PacketRecord),
+		extraData: make(map[EventKey][]*ExtraDataRecord),
+	}
+
+	for {
+		record, err := ReadRecord(file)
+		if err != nil {
+			if atEOF(err) {
+				break
+			}
+			return nil, err
+		}
+
+		switch record := record.(type) {
+		case *EventRecord:
+			store.events[record.Key()] = record
+			store.chronological = append(store.chronological, record)
+		case *PacketRecord:
+			key := EventKey{record.SensorId, record.EventId}
+			store.packets[key] = append(store.packets[key], record)
+		case *ExtraDataRecord:
+			key := EventKey{record.SensorId, record.EventId}
+			store.extraData[key] = append(store.extraData[key], record)
+		}
+	}
+
+	sort.SliceStable(store.chronological, func(i, j int) bool {
+		return store.chronological[i].Timestamp().Before(
+			store.chronological[j].Timestamp())
+	})
+
+	return store, nil
+}
+
+// Event returns the event with the provided key, or nil if there is
+// no such event.
+func (s *Store) Event(key EventKey) *EventRecord {
+	return s.events[key]
+}
+
+// PacketsFor returns the packets logged for the event with the
+// provided key in the order they were read.
+func (s *Store) PacketsFor(key EventKey) []*PacketRecord {
+	return s.packets[key]
+}
+
+// ExtraDataFor returns the extra data logged for the event with the
+// provided key in the order they were read.
+func (s *Store) ExtraDataFor(key EventKey) []*ExtraDataRecord {
+	return s.extraData[key]
+}
+
+// Events returns all the events sorted by time.
+func (s *Store) Events() []*EventRecord {
+	return s.chronological
+}
+
+// EventsInRange returns the events, sorted by time, that occurred at
+// or after start and before end.
+func (s *Store) EventsInRange(start, end time.Time) []*EventRecord {
+	first := sort.Search(len(s.chronological), func(i int) bool {
+		return !s.chronological[i].Timestamp().Before(start)
+	})
+	last := sort.Search(len(s.chronological), func(i int) bool {
+		return !s.chronological[i].Timestamp().Before(end)
+	})
+	if last < first {
+		return nil
+	}
+	return s.chronological[first:last]
+}
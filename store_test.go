@@ -0,0 +1,44 @@
+package unified2
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	input, err := os.Open("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	store, err := LoadStore(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := EventKey{SensorId: 0, EventId: 89}
+	event := store.Event(key)
+	if event == nil {
+		t.Fatal("expected event to be found")
+	}
+	if len(store.PacketsFor(key)) != 15 {
+		t.Fatalf("expected 15 packets, got %d", len(store.PacketsFor(key)))
+	}
+	if len(store.ExtraDataFor(key)) != 1 {
+		t.Fatalf("expected 1 extra data, got %d", len(store.ExtraDataFor(key)))
+	}
+	if store.Event(EventKey{SensorId: 1, EventId: 89}) != nil {
+		t.Fatal("did not expect an event for sensor 1")
+	}
+
+	timestamp := event.Timestamp()
+	if len(store.EventsInRange(timestamp, timestamp.Add(time.Second))) != 1 {
+		t.Fatal("expected event to be in range")
+	}
+	if len(store.EventsInRange(timestamp.Add(time.Microsecond),
+		timestamp.Add(time.Second))) != 0 {
+		t.Fatal("did not expect event to be in range")
+	}
+}
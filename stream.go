@@ -0,0 +1,240 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// PollInterval is how often ReadRecordContext checks a file for more
+// data while waiting for a record.
+var PollInterval = 250 * time.Millisecond
+
+// ErrReadTimeout is returned by readers waiting for records to be
+// written when none is available within their ReadTimeout.
+var ErrReadTimeout = errors.New("Unified2 read timed out waiting for a record")
+
+// RecordResult is a record or error delivered by Stream.
+type RecordResult struct {
+	Record *RecordContainer
+	Err    error
+}
+
+// Stream reads and decodes records from file in a new goroutine,
+// delivering them on the returned channel.
+//
+// The channel is unbuffered, so the goroutine only reads the next
+// record once the previous one has been received.  The channel is
+// closed when the end of the file is reached on a record boundary.  If
+// an error occurs, including an incomplete record at the end of the
+// file, a RecordResult holding the error is delivered and then the
+// channel is closed.
+//
+// Cancelling ctx stops the goroutine and closes the channel without
+// delivering an error.  The file must not be used by the caller until
+// the channel has been closed.
+func Stream(ctx context.Context, file io.ReadSeeker) <-chan RecordResult {
+	results := make(chan RecordResult)
+
+	go func() {
+		defer close(results)
+
+		for {
+			record, err := readRecord(file)
+			if atEOF(err) {
+				return
+			}
+			result := RecordResult{record, err}
+
+			select {
+			case results <- result:
+			case <-ctx.Done():
+				return
+			}
+
+			if result.Err != nil {
+				return
+			}
+		}
+	}()
+
+	return results
+}
+
+// ReadRecordContext reads and decodes the next record of file, waiting
+// for it to be written if the file ends before a complete record, as
+// when following a file still being written.
+//
+// While waiting the file is checked for more data every PollInterval.
+// If ctx is cancelled first ctx.Err() is returned, leaving the file
+// positioned at the start of the record.  Errors other than the file
+// ending before a complete record are returned as by ReadRecord.
+func ReadRecordContext(ctx context.Context, file io.ReadSeeker) (*RecordContainer, error) {
+	for {
+		container, err := readRecord(file)
+		if err == nil {
+			return container, nil
+		}
+		e := &ErrBufferTooSmall{}
+		if !errors.As(err, &e) {
+			return nil, err
+		}
+
+		if err := sleepContext(ctx, PollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleepContext sleeps for d, returning ctx.Err() if ctx is cancelled
+// first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// timeoutContext returns a context for waiting up to timeout for a
+// record, without a timeout if timeout is 0.  A wait on it returning
+// context.DeadlineExceeded while ctx is not done has timed out.
+func timeoutContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// StreamReader reads and decodes records from an io.Reader in a
+// goroutine, delivering them on a channel, for use in pipelines built
+// on channels:
+//
+//	stream := unified2.NewStreamReader(input)
+//	for container := range stream.Records() {
+//		...
+//	}
+//	if err := <-stream.Errors(); err != nil {
+//		...
+//	}
+//
+// StreamReaders should be created with NewStreamReader().
+type StreamReader struct {
+	reader  io.Reader
+	records chan *RecordContainer
+	errors  chan error
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// NewStreamReader creates a new StreamReader and starts reading
+// records from reader, as by a Reader.
+func NewStreamReader(reader io.Reader) *StreamReader {
+	s := &StreamReader{
+		reader:  reader,
+		records: make(chan *RecordContainer),
+		errors:  make(chan error, 1),
+		stop:    make(chan struct{}),
+	}
+	go s.run(NewReader(reader))
+	return s
+}
+
+func (s *StreamReader) run(reader *Reader) {
+	defer close(s.errors)
+	defer close(s.records)
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		container, err := reader.Next()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			// An error from closing the reader is not reported.
+			select {
+			case <-s.stop:
+			default:
+				s.errors <- err
+			}
+			return
+		}
+
+		select {
+		case s.records <- container:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Records returns the channel the records are delivered on.  The
+// channel is closed at the end of the input, on an error or once
+// stopped.
+func (s *StreamReader) Records() <-chan *RecordContainer {
+	return s.records
+}
+
+// Errors returns the channel the error that stopped reading, if any,
+// is delivered on, including io.ErrUnexpectedEOF if the input ended
+// with a partial record.  The channel is closed after the Records
+// channel, so receiving from it once the records have been read
+// returns the error or nil.
+func (s *StreamReader) Errors() <-chan error {
+	return s.errors
+}
+
+// Stop stops reading.  A read already in progress is not interrupted,
+// the goroutine exits once it returns, closing the channels.  Stop may
+// be called more than once.
+func (s *StreamReader) Stop() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+}
+
+// Close stops reading as Stop, and closes the underlying reader if it
+// is an io.Closer, interrupting a read in progress.
+func (s *StreamReader) Close() error {
+	s.Stop()
+	if closer, ok := s.reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package unified2
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	input, err := os.Open("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	count := 0
+	for result := range Stream(context.Background(), input) {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+		count++
+	}
+	if count != 17 {
+		t.Fatalf("expected 17 records, got %d", count)
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	input, err := os.Open("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := Stream(ctx, input)
+	<-results
+	cancel()
+
+	// The channel must be closed after cancellation, at most one
+	// more record that was ready may be delivered.
+	count := 0
+	for range results {
+		count++
+	}
+	if count > 1 {
+		t.Fatalf("expected at most 1 record after cancel, got %d", count)
+	}
+}
+
+func TestReadRecordContext(t *testing.T) {
+	data, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpdir, err := ioutil.TempDir("", "unified2-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpdir)
+	filename := tmpdir + "/unified2.log"
+
+	// Start with the first record and part of the second.
+	if err := ioutil.WriteFile(filename, data[:100], 0644); err != nil {
+		t.Fatal(err)
+	}
+	input, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	defer func(interval time.Duration) {
+		PollInterval = interval
+	}(PollInterval)
+	PollInterval = time.Millisecond
+
+	ctx := context.Background()
+	if _, err := ReadRecordContext(ctx, input); err != nil {
+		t.Fatal(err)
+	}
+
+	// Waiting for a record is stopped by cancelling the context.
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := ReadRecordContext(timeout, input); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if offset, _ := input.Seek(0, 1); offset != 68 {
+		t.Fatalf("expected offset 68, got %d", offset)
+	}
+
+	// The rest of the record is written while waiting.
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		output, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return
+		}
+		output.Write(data[100:])
+		output.Close()
+	}()
+	container, err := ReadRecordContext(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.Type != UNIFIED2_EXTRA_DATA {
+		t.Fatalf("unexpected record %+v", container)
+	}
+}
+
+func TestStreamReader(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := NewStreamReader(bytes.NewReader(buf))
+	count := 0
+	for range stream.Records() {
+		count++
+	}
+	if err := <-stream.Errors(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 17 {
+		t.Fatalf("expected 17 records, got %d", count)
+	}
+
+	// A partial record at the end of the input.
+	stream = NewStreamReader(bytes.NewReader(buf[:100]))
+	count = 0
+	for range stream.Records() {
+		count++
+	}
+	if err := <-stream.Errors(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected 1 record, got %d", count)
+	}
+}
+
+func TestStreamReaderStop(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream := NewStreamReader(bytes.NewReader(buf))
+	<-stream.Records()
+	stream.Stop()
+	stream.Stop()
+
+	// At most the record being delivered when stopped may follow.
+	count := 0
+	for range stream.Records() {
+		count++
+	}
+	if count > 1 {
+		t.Fatalf("expected at most 1 record after Stop, got %d", count)
+	}
+	if err := <-stream.Errors(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Close interrupts a read waiting for more input.
+func TestStreamReaderClose(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr, pw := io.Pipe()
+	go pw.Write(buf[:100])
+
+	stream := NewStreamReader(pr)
+	<-stream.Records()
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for range stream.Records() {
+		t.Fatal("unexpected record after Close")
+	}
+	if err := <-stream.Errors(); err != nil {
+		t.Fatal(err)
+	}
+}
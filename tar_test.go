@@ -0,0 +1,84 @@
+package unified2
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadTar(t *testing.T) {
+	unified2, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"spool/unified2.log.1", unified2},
+		{"README", []byte("not a unified2 file")},
+		{"spool/unified2.log.2.gz", gzipBytes(t, unified2)},
+	}
+
+	var buf bytes.Buffer
+	archive := tar.NewWriter(&buf)
+	for _, file := range files {
+		err := archive.WriteHeader(&tar.Header{
+			Name:     file.name,
+			Mode:     0644,
+			Size:     int64(len(file.data)),
+			Typeflag: tar.TypeReg,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := archive.Write(file.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]int{}
+	err = ReadTar(bytes.NewReader(gzipBytes(t, buf.Bytes())),
+		func(name string, src RecordSource) error {
+			for {
+				_, err := src.Next()
+				if err != nil {
+					if !atEOF(err) {
+						return err
+					}
+					return nil
+				}
+				counts[name]++
+			}
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]int{
+		"spool/unified2.log.1":    17,
+		"spool/unified2.log.2.gz": 17,
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Fatalf("expected %v, got %v", expected, counts)
+	}
+}
@@ -27,7 +27,13 @@
 /*
 
 Package unified2 provides a decoder for unified v2 log files
-produced by Snort and Suricata.
+produced by Snort and Suricata, and an encoder for writing them.
+
+The functions taking an io.ReadSeeker, such as ReadRecord, seek back
+to the start of an incomplete record so reading can be retried once
+more data has been written.  Sources that can not seek, such as pipes,
+network connections and compressed streams, are read with a Reader,
+created with NewReader, which buffers incomplete records instead.
 
 */
 package unified2
@@ -55,6 +61,14 @@ func (e *ErrBufferTooSmall) Error() string {
 	return fmt.Sprintf("Missing %d bytes to parse full record", e.MissingBytes)
 }
 
+// atEOF returns true if err indicates that no data at all was
+// available for the next record, that is the end of the input was
+// reached cleanly on a record boundary.
+func atEOF(err error) bool {
+	e := &ErrBufferTooSmall{}
+	return errors.As(err, &e) && e.MissingBytes == rawHeaderLen
+}
+
 // Unified2 record types.
 const (
 	UNIFIED2_PACKET          = 2
@@ -73,6 +87,43 @@ type RawHeader struct {
 	Len  uint32
 }
 
+// The length of a RawHeader.
+const rawHeaderLen = 8
+
+// DefaultMaxRecordLen is the maximum length of a record read by
+// ReadRawRecord.  Real records are much smaller, a longer length is
+// most likely a corrupt header, and is not allocated.
+var DefaultMaxRecordLen uint32 = 64 * 1024 * 1024
+
+// RecordTooLargeError is returned when the length in a record header
+// exceeds the maximum record length.  The record is not read, nor is
+// its length allocated.
+//
+// errors.Is reports a RecordTooLargeError as both DecodingError and
+// ErrMalformedRecord, as the header is most likely corrupt.
+type RecordTooLargeError struct {
+	// Type is the type of the record.
+	Type uint32
+
+	// Offset is the offset of the record in the input.
+	Offset int64
+
+	// Len is the length in the record header, and MaxLen the maximum
+	// length it exceeds.
+	Len    uint32
+	MaxLen uint32
+}
+
+func (e *RecordTooLargeError) Error() string {
+	return fmt.Sprintf("%v: record type %d at offset %d: record length %d exceeds maximum of %d",
+		DecodingError, e.Type, e.Offset, e.Len, e.MaxLen)
+}
+
+// Is returns true if target is DecodingError or ErrMalformedRecord.
+func (e *RecordTooLargeError) Is(target error) bool {
+	return target == DecodingError || target == ErrMalformedRecord
+}
+
 // RawRecord is a holder type for a raw un-decoded record.
 type RawRecord struct {
 	Type uint32
@@ -84,6 +135,13 @@ type RawRecord struct {
 // This struct is used to represent the decoded form of all the event
 // types.  The difference between an IPv4 and IPv6 event will be the
 // length of the IP address IpSource and IpDestination.
+//
+// Producers logging both the outer and inner VLAN tags (QinQ) extend
+// the UNIFIED2_EVENT_V2 and UNIFIED2_EVENT_V2_IP6 layouts with 4
+// bytes: the inner VLAN id followed by 2 bytes of padding.  If
+// DecodeInnerVlan is enabled, InnerVlanId is set and HasInnerVlan
+// returns true for these records, otherwise the bytes are left in the
+// Trailer.
 type EventRecord struct {
 	SensorId          uint32
 	EventId           uint32
@@ -105,7 +163,15 @@ type EventRecord struct {
 	MplsLabel         uint32
 	VlanId            uint16
 	Pad2              uint16
+	InnerVlanId       uint16
 	AppId             string
+
+	// Trailer holds any bytes following the known layout of the
+	// event.  An empty Trailer is the normal case, a non-empty one
+	// indicates a producer that adds fields unknown to this package.
+	Trailer []byte
+
+	hasInnerVlan bool
 }
 
 // PacketRecord is a struct representing a decoded packet record.
@@ -118,6 +184,10 @@ type PacketRecord struct {
 	LinkType          uint32
 	Length            uint32
 	Data              []byte
+
+	// Trailer holds any bytes following the Length bytes of packet
+	// data.  An empty Trailer is the normal case.
+	Trailer []byte
 }
 
 // The length of a PacketRecord before variable length data.
@@ -134,12 +204,72 @@ type ExtraDataRecord struct {
 	DataType    uint32
 	DataLength  uint32
 	Data        []byte
+
+	// Trailer holds any bytes following the data as described by
+	// DataLength.  An empty Trailer is the normal case.
+	Trailer []byte
 }
 
 // The length of an ExtraDataRecord before variable length data.
 const EXTRA_DATA_RECORD_HDR_LEN = 32
 
-// ReadRawRecord reads a raw record from the provided file.
+// The ExtraDataRecord DataLength includes the length of the DataType
+// and DataLength fields themselves.
+const extraDataLengthOverhead = 8
+
+// validRecordType returns true if recordType is one of the known
+// unified2 record types.
+func validRecordType(recordType uint32) bool {
+	switch recordType {
+	case UNIFIED2_EVENT,
+		UNIFIED2_EVENT_IP6,
+		UNIFIED2_EVENT_V2,
+		UNIFIED2_EVENT_V2_IP6,
+		UNIFIED2_EVENT_APPID,
+		UNIFIED2_EVENT_APPID_IP6,
+		UNIFIED2_PACKET,
+		UNIFIED2_EXTRA_DATA:
+		return true
+	}
+	return false
+}
+
+// NextRawRecord parses a single raw record from the start of buf,
+// returning the record and the remaining bytes of buf.
+//
+// If buf does not contain a complete record ErrBufferTooSmall is
+// returned with the number of bytes required to complete the header
+// or record, allowing the caller to wait for more data and try
+// again.  ErrInvalidHeader is returned if the record type is not
+// known.
+//
+// The Data of the returned record refers to the memory of buf, it is
+// not copied.  Records decoded from it also refer to buf, so buf must
+// not be modified or reused while they are in use.  The Copy method of
+// the decoded records returns a record independent of buf.
+func NextRawRecord(buf []byte) (*RawRecord, []byte, error) {
+	if len(buf) < rawHeaderLen {
+		return nil, buf, &ErrBufferTooSmall{int64(rawHeaderLen - len(buf))}
+	}
+
+	header := RawHeader{
+		Type: binary.BigEndian.Uint32(buf[0:4]),
+		Len:  binary.BigEndian.Uint32(buf[4:8]),
+	}
+	if !validRecordType(header.Type) {
+		return nil, buf, fmt.Errorf("%w: Unknown record type", ErrInvalidHeader)
+	}
+
+	end := int64(rawHeaderLen) + int64(header.Len)
+	if int64(len(buf)) < end {
+		return nil, buf, &ErrBufferTooSmall{end - int64(len(buf))}
+	}
+
+	return &RawRecord{header.Type, buf[rawHeaderLen:end]}, buf[end:], nil
+}
+
+// ReadRawRecord reads a raw record from the provided file.  To read
+// from an io.Reader that can not seek use a Reader.
 //
 // On error, err will no non-nil.  Expected error values areL
 // - ErrBufferTooSmall if EOF has been reached. Contains number of bytes
@@ -147,79 +277,206 @@ const EXTRA_DATA_RECORD_HDR_LEN = 32
 // - ErrInvalidHeader if the Header at the current position does not
 //   contain a valid record type
 // - ErrMalformedRecord if the body of the record could not be properly parsed
-// In the case of ErrBufferTooSmall and ErrInvalidHeader the file offset will be
-// reset back to where it was upon entering this function so it is ready to
-// be read from again if it is expected more data will be written to
-// the file.
+// - a RecordTooLargeError, reported by errors.Is as DecodingError, if
+//   the record is longer than DefaultMaxRecordLen
+// In the case of ErrBufferTooSmall, ErrInvalidHeader and DecodingError
+// the file offset will be reset back to where it was upon entering this
+// function so it is ready to be read from again if it is expected more
+// data will be written to the file.
 func ReadRawRecord(file io.ReadSeeker) (*RawRecord, error) {
-	var header RawHeader
+	return readRawRecord(file, DefaultMaxRecordLen, nil, false)
+}
+
+// readRawRecord reads a raw record as ReadRawRecord, returning a
+// RecordTooLargeError for records longer than maxLen.  The record data is
+// read into buf if large enough.  If unknown is set records of unknown
+// types are read instead of returning ErrInvalidHeader.
+func readRawRecord(file io.ReadSeeker, maxLen uint32, buf []byte, unknown bool) (*RawRecord, error) {
+	var rawHeader [rawHeaderLen]byte
 
 	/* Get the current offset so we can seek back to it. */
 	offset, _ := file.Seek(0, 1)
 
 	/* Now read in the header. */
-	err := binary.Read(file, binary.BigEndian, &header)
-	if err != nil {
-		read, _ := file.Seek(0, 1)
-
+	if n, err := io.ReadFull(file, rawHeader[:]); err != nil {
 		file.Seek(offset, 0)
-		return nil, &ErrBufferTooSmall{8 - (read - offset)}
+		return nil, &ErrBufferTooSmall{int64(rawHeaderLen - n)}
+	}
+	header := RawHeader{
+		Type: binary.BigEndian.Uint32(rawHeader[0:4]),
+		Len:  binary.BigEndian.Uint32(rawHeader[4:8]),
 	}
 
-	switch header.Type {
-	case UNIFIED2_EVENT,
-		UNIFIED2_EVENT_IP6,
-		UNIFIED2_EVENT_V2,
-		UNIFIED2_EVENT_V2_IP6,
-		UNIFIED2_EVENT_APPID,
-		UNIFIED2_EVENT_APPID_IP6,
-		UNIFIED2_PACKET,
-		UNIFIED2_EXTRA_DATA:
-	default:
+	if !unknown && !validRecordType(header.Type) {
 		file.Seek(offset, 0)
 		return nil, fmt.Errorf("%w: Unknown record type", ErrInvalidHeader)
 	}
 
+	if header.Len > maxLen {
+		file.Seek(offset, 0)
+		return nil, &RecordTooLargeError{header.Type, offset, header.Len, maxLen}
+	}
+
 	/* Create a buffer to hold the raw record data and read the
 	/* record data into it */
-	data := make([]byte, header.Len)
-	n, err := file.Read(data)
-	if err != nil {
-		// must be EOF
-		file.Seek(offset, 0)
-		return nil, &ErrBufferTooSmall{int64(header.Len)}
+	var data []byte
+	if uint32(cap(buf)) >= header.Len {
+		data = buf[:header.Len]
+	} else {
+		data = make([]byte, header.Len)
 	}
-	if uint32(n) != header.Len {
+	n, err := io.ReadFull(file, data)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
 		file.Seek(offset, 0)
 		return nil, &ErrBufferTooSmall{int64(header.Len) - int64(n)}
+	} else if err != nil {
+		file.Seek(offset, 0)
+		return nil, err
 	}
 
 	return &RawRecord{header.Type, data}, nil
 }
 
+// PeekType returns the type of the next record in file without
+// consuming it.  The file offset is always reset back to where it was
+// upon entering this function.
+//
+// If the header is incomplete ErrBufferTooSmall is returned, and if
+// the record type is not known ErrInvalidHeader.
+func PeekType(file io.ReadSeeker) (uint32, error) {
+	var header RawHeader
+
+	offset, _ := file.Seek(0, 1)
+	err := binary.Read(file, binary.BigEndian, &header)
+	read, _ := file.Seek(0, 1)
+	file.Seek(offset, 0)
+
+	if err != nil {
+		return 0, &ErrBufferTooSmall{rawHeaderLen - (read - offset)}
+	}
+	if !validRecordType(header.Type) {
+		return 0, fmt.Errorf("%w: Unknown record type", ErrInvalidHeader)
+	}
+	return header.Type, nil
+}
+
 // ReadRecord reads a record from the provided file and returns a
-// decoded record.
+// decoded record.  To read from an io.Reader that can not seek use a
+// Reader.
 //
-// On error, err will be non-nil.  Expected error values are io.EOF
-// when the end of the file has been reached or io.ErrUnexpectedEOF if
-// a complete record was unable to be read.
+// On error, err will be non-nil.  At the end of the file an
+// ErrBufferTooSmall error is returned, holding the number of bytes
+// missing to read the next record header, 8 at the end of the file on
+// a record boundary, or the rest of an incomplete record.
 //
-// In the case of io.ErrUnexpectedEOF the file offset will be reset
-// back to where it was upon entering this function so it is ready to
-// be read from again if it is expected that more data will be written to
+// In the case of ErrBufferTooSmall the file offset will be reset back
+// to where it was upon entering this function so it is ready to be
+// read from again if it is expected that more data will be written to
 // the file.
 //
-// If an error occurred during decoding of the read data a
-// DecodingError will be returned.  This likely means the input is
-// corrupt.
+// If an error occurred during decoding of the read data, including the
+// record being shorter than the fixed fields of its type, a *DecodeError
+// will be returned, which errors.Is reports as DecodingError.  This
+// likely means the input is corrupt.  The record has been consumed, so
+// reading can continue with the following record.
 func ReadRecord(file io.ReadSeeker) (interface{}, error) {
+	container, err := readRecord(file)
+	if err != nil {
+		return nil, err
+	}
+	return container.Record, nil
+}
+
+// ReadRecords reads and decodes up to max records from file.
+//
+// If an error occurs before max records have been read, the records
+// read so far are returned along with the error.  Reaching the end of
+// the file on a record boundary is returned as io.EOF.  Other errors are
+// as returned by ReadRecord, an incomplete record at the end of the
+// file being an ErrBufferTooSmall error with the file offset left at
+// the start of the record.  A record that can not be decoded has been
+// consumed, so reading can continue with the following record.
+func ReadRecords(file io.ReadSeeker, max int) ([]*RecordContainer, error) {
+	var records []*RecordContainer
+	for len(records) < max {
+		container, err := readRecord(file)
+		if atEOF(err) {
+			return records, io.EOF
+		} else if err != nil {
+			return records, err
+		}
+		records = append(records, container)
+	}
+	return records, nil
+}
 
+// readRecord reads and decodes the next record of file into a
+// RecordContainer, setting the Offset of any DecodeError.
+func readRecord(file io.ReadSeeker) (*RecordContainer, error) {
 	record, err := ReadRawRecord(file)
 	if err != nil {
 		return nil, err
 	}
 
+	end, _ := file.Seek(0, 1)
+	offset := end - rawHeaderLen - int64(len(record.Data))
+
+	container, err := DecodeRawRecord(record)
+	if err != nil {
+		return nil, withOffset(err, offset)
+	}
+	container.Offset = offset
+	return container, nil
+}
+
+// copyBytes returns a copy of b, or nil if b is nil.
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// recordBuffers holds the records reused by decodeRawRecordInto.
+type recordBuffers struct {
+	event  EventRecord
+	packet PacketRecord
+	extra  ExtraDataRecord
+}
+
+// DecodeRawRecord decodes a raw record, as read by ReadRawRecord, into
+// a RecordContainer holding the decoded record, as done by ReadRecord.
+//
+// DecodeRawRecord uses no shared state, so raw records can be decoded
+// concurrently, for example by a pool of goroutines fed by a single
+// reader.  The decoded record refers to the memory of record.Data, see
+// the decoder of each record type.  Decoding failures are returned as
+// a *DecodeError, without an Offset as that is not known here, and the
+// Offset of the container is -1.
+func DecodeRawRecord(record *RawRecord) (*RecordContainer, error) {
+	container := &RecordContainer{
+		Offset: -1,
+		Len:    rawHeaderLen + int64(len(record.Data)),
+	}
+	if err := decodeRawRecordInto(record, container, nil); err != nil {
+		return nil, err
+	}
+	return container, nil
+}
+
+// decodeRawRecordInto decodes a raw record into container.  If buffers
+// is not nil the record is decoded into the record of buffers for its
+// type, otherwise a new record is allocated.  Decoding failures,
+// including records shorter than the minimum length of their type, are
+// returned as a *DecodeError without an Offset.
+func decodeRawRecordInto(record *RawRecord, container *RecordContainer, buffers *recordBuffers) error {
+	// Catch records framed with the wrong type before decoding.
+	if err := checkMinLen(record.Type, len(record.Data)); err != nil {
+		return err
+	}
+
 	var decoded interface{}
+	var err error
 
 	switch record.Type {
 	case UNIFIED2_EVENT,
@@ -228,17 +485,40 @@ func ReadRecord(file io.ReadSeeker) (interface{}, error) {
 		UNIFIED2_EVENT_V2_IP6,
 		UNIFIED2_EVENT_APPID,
 		UNIFIED2_EVENT_APPID_IP6:
-		decoded, err = DecodeEventRecord(record.Type, record.Data)
+		var event *EventRecord
+		if buffers != nil {
+			event = &buffers.event
+		} else {
+			event = &EventRecord{}
+		}
+		err = DecodeEventRecordInto(record.Type, record.Data, event)
+		decoded = event
 	case UNIFIED2_PACKET:
-		decoded, err = DecodePacketRecord(record.Data)
+		var packet *PacketRecord
+		if buffers != nil {
+			packet = &buffers.packet
+		} else {
+			packet = &PacketRecord{}
+		}
+		err = DecodePacketRecordInto(record.Data, packet)
+		decoded = packet
 	case UNIFIED2_EXTRA_DATA:
-		decoded, err = DecodeExtraDataRecord(record.Data)
+		var extra *ExtraDataRecord
+		if buffers != nil {
+			extra = &buffers.extra
+		} else {
+			extra = &ExtraDataRecord{}
+		}
+		err = DecodeExtraDataRecordInto(record.Data, extra)
+		decoded = extra
+	default:
+		return fmt.Errorf("%w: Unknown record type", ErrInvalidHeader)
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrMalformedRecord, err)
-	} else if decoded != nil {
-		return decoded, nil
+		return err
 	}
-	return nil, fmt.Errorf("Decode function returned nil record but no error")
+	container.Type = record.Type
+	container.Record = decoded
+	return nil
 }
@@ -1,6 +1,7 @@
 package unified2_test
 
 import (
+	"errors"
 	"github.com/jasonish/go-unified2"
 	"io"
 	"log"
@@ -26,7 +27,7 @@ func ExampleReadRecord() {
 				//
 				// Lets break for the purpose of this example.
 				break
-			} else if err == unified2.DecodingError {
+			} else if errors.Is(err, unified2.DecodingError) {
 				// Error decoding a record, probably corrupt.
 				log.Fatal(err)
 			}
@@ -48,6 +49,33 @@ func ExampleReadRecord() {
 
 }
 
+// RecordScanner example.
+func ExampleRecordScanner() {
+
+	file, err := os.Open("test/multi-record-event.log")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	scanner := unified2.NewRecordScanner(file)
+	for scanner.Scan() {
+		switch record := scanner.Record().Record.(type) {
+		case *unified2.EventRecord:
+			log.Printf("Event: EventId=%d\n", record.EventId)
+		case *unified2.ExtraDataRecord:
+			log.Printf("- Extra Data: EventId=%d\n", record.EventId)
+		case *unified2.PacketRecord:
+			log.Printf("- Packet: EventId=%d\n", record.EventId)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		// An incomplete record at the end of the file, or a
+		// corrupt record.
+		log.Fatal(err)
+	}
+}
+
 // RecordReader example.
 func ExampleRecordReader() {
 
@@ -67,7 +95,7 @@ func ExampleRecordReader() {
 				//
 				// Lets break for the purpose of this example.
 				break
-			} else if err == unified2.DecodingError {
+			} else if errors.Is(err, unified2.DecodingError) {
 				// Error decoding a record, probably corrupt.
 				log.Fatal(err)
 			}
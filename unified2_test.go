@@ -1,11 +1,33 @@
 package unified2
 
 import (
+	"bytes"
 	"errors"
+	"io"
+	"io/ioutil"
 	"os"
+	"reflect"
+	"runtime"
+	"sync"
 	"testing"
 )
 
+// Readers that own files or goroutines are io.Closers.
+var (
+	_ io.Closer = (*RecordReader)(nil)
+	_ io.Closer = (*SpoolRecordReader)(nil)
+	_ io.Closer = (*FileReader)(nil)
+	_ io.Closer = (*FollowingReader)(nil)
+	_ io.Closer = (*StreamReader)(nil)
+	_ io.Closer = (*ConnReader)(nil)
+	_ io.Closer = (*MergeReader)(nil)
+	_ io.Closer = (*MappedReader)(nil)
+	_ io.Closer = (*Reader)(nil)
+	_ io.Closer = (*FilterReader)(nil)
+	_ io.Closer = (*AggregateReader)(nil)
+	_ io.Closer = (*RecordScanner)(nil)
+)
+
 // Check that we get EOF at the end of a file.
 func TestReadRecordEOF(t *testing.T) {
 
@@ -160,3 +182,261 @@ func TestRecordReaderWithOffset(t *testing.T) {
 	}
 
 }
+
+func TestNextRawRecord(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first record is a 60 byte event.
+	raw, rest, err := NextRawRecord(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw.Type != UNIFIED2_EVENT_V2 || len(raw.Data) != 60 {
+		t.Fatalf("unexpected record: type=%d, len=%d", raw.Type, len(raw.Data))
+	}
+	if len(rest) != len(buf)-68 {
+		t.Fatalf("unexpected remainder length %d", len(rest))
+	}
+
+	// Short header.
+	_, rest, err = NextRawRecord(buf[:5])
+	if e := (&ErrBufferTooSmall{}); !errors.As(err, &e) || e.MissingBytes != 3 {
+		t.Fatalf("expected ErrBufferTooSmall with MissingBytes == 3, got %v", err)
+	}
+	if len(rest) != 5 {
+		t.Fatalf("expected buffer to be returned untouched")
+	}
+
+	// Short body.
+	_, _, err = NextRawRecord(buf[:20])
+	if e := (&ErrBufferTooSmall{}); !errors.As(err, &e) || e.MissingBytes != 48 {
+		t.Fatalf("expected ErrBufferTooSmall with MissingBytes == 48, got %v", err)
+	}
+
+	// Read all the records.
+	count := 0
+	for len(buf) > 0 {
+		_, buf, err = NextRawRecord(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+	}
+	if count != 17 {
+		t.Fatalf("expected 17 records, got %d", count)
+	}
+
+	_, _, err = NextRawRecord([]byte{0, 0, 0, 99, 0, 0, 0, 0})
+	if !errors.Is(err, ErrInvalidHeader) {
+		t.Fatalf("expected ErrInvalidHeader, got %v", err)
+	}
+}
+
+// eagerEOFReader is a ReadSeeker that returns io.EOF along with the
+// last bytes of its data.
+type eagerEOFReader struct {
+	*bytes.Reader
+}
+
+func (r *eagerEOFReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == nil && r.Reader.Len() == 0 {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func TestReadRecordDataWithEOF(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := &eagerEOFReader{bytes.NewReader(buf)}
+	count := 0
+	for {
+		_, err := ReadRecord(input)
+		if err != nil {
+			if e := (&ErrBufferTooSmall{}); !errors.As(err, &e) || e.MissingBytes != 8 {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			break
+		}
+		count++
+	}
+	if count != 17 {
+		t.Fatalf("expected 17 records, got %d", count)
+	}
+}
+
+func TestPeekType(t *testing.T) {
+	input, err := os.Open("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	for _, expected := range []uint32{UNIFIED2_EVENT_V2, UNIFIED2_EXTRA_DATA, UNIFIED2_PACKET} {
+		recordType, err := PeekType(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recordType != expected {
+			t.Fatalf("expected type %d, got %d", expected, recordType)
+		}
+		if _, err := ReadRecord(input); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	input.Seek(0, 2)
+	if _, err := PeekType(input); !atEOF(err) {
+		t.Fatalf("expected ErrBufferTooSmall at end of file, got %v", err)
+	}
+}
+
+// oneByteReader is a ReadSeeker that returns at most one byte per
+// read, like a pipe being written to slowly.
+type oneByteReader struct {
+	*bytes.Reader
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return r.Reader.Read(p)
+}
+
+func TestReadRecordOneByteReads(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := &oneByteReader{bytes.NewReader(buf)}
+	for i := 0; i < 17; i++ {
+		if _, err := ReadRecord(input); err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+	}
+	if _, err := ReadRecord(input); !atEOF(err) {
+		t.Fatalf("expected end of file, got %v", err)
+	}
+
+	// A genuinely short record is still reported and the offset reset.
+	input = &oneByteReader{bytes.NewReader(buf[:100])}
+	ReadRecord(input)
+	_, err = ReadRecord(input)
+	if e := (&ErrBufferTooSmall{}); !errors.As(err, &e) || e.MissingBytes != 18602-24 {
+		t.Fatalf("expected ErrBufferTooSmall, got %v", err)
+	}
+	if offset, _ := input.Seek(0, 1); offset != 68 {
+		t.Fatalf("expected offset 68, got %d", offset)
+	}
+}
+
+func TestReadRawRecordMaxLen(t *testing.T) {
+	// A packet header claiming a length of 4GB.
+	input := bytes.NewReader([]byte{
+		0x00, 0x00, 0x00, 0x02, 0xff, 0xff, 0xff, 0xff,
+		0x00, 0x00, 0x00, 0x00,
+	})
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := ReadRawRecord(input)
+	runtime.ReadMemStats(&after)
+
+	if !errors.Is(err, DecodingError) {
+		t.Fatalf("expected DecodingError, got %v", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1024*1024 {
+		t.Fatalf("expected no large allocation, %d bytes allocated", allocated)
+	}
+	if offset, _ := input.Seek(0, 1); offset != 0 {
+		t.Fatalf("expected offset 0, got %d", offset)
+	}
+}
+
+func TestReadRecords(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := bytes.NewReader(buf)
+	records, err := ReadRecords(file, 10)
+	if err != nil || len(records) != 10 {
+		t.Fatalf("expected 10 records, got %d, error %v", len(records), err)
+	}
+	records, err = ReadRecords(file, 10)
+	if err != io.EOF || len(records) != 7 {
+		t.Fatalf("expected 7 records at end of file, got %d, error %v",
+			len(records), err)
+	}
+
+	// An incomplete record at the end of the file.
+	file = bytes.NewReader(buf[:18800])
+	records, err = ReadRecords(file, 10)
+	e := &ErrBufferTooSmall{}
+	if !errors.As(err, &e) || atEOF(err) || len(records) != 2 {
+		t.Fatalf("expected 2 records and incomplete record, got %d, error %v",
+			len(records), err)
+	}
+	if offset, _ := file.Seek(0, 1); offset != 18678 {
+		t.Fatalf("expected offset 18678, got %d", offset)
+	}
+
+	// The records before a corrupt record are returned with the error.
+	records, err = ReadRecords(bytes.NewReader(corruptLog(t)), 10)
+	if !errors.Is(err, DecodingError) || len(records) != 1 {
+		t.Fatalf("expected 1 record and DecodingError, got %d, error %v",
+			len(records), err)
+	}
+}
+
+// Records decoded concurrently must match those decoded by ReadRecord.
+func TestDecodeRawRecordConcurrent(t *testing.T) {
+	input, err := os.Open("test/multi-record-event-x2.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	var raws []*RawRecord
+	for {
+		raw, err := ReadRawRecord(input)
+		if atEOF(err) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		raws = append(raws, raw)
+	}
+
+	decoded := make([]*RecordContainer, len(raws))
+	var wg sync.WaitGroup
+	for i := range raws {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			decoded[i], _ = DecodeRawRecord(raws[i])
+		}(i)
+	}
+	wg.Wait()
+
+	input.Seek(0, 0)
+	for i := range raws {
+		expected, err := ReadRecord(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded[i] == nil || !reflect.DeepEqual(decoded[i].Record, expected) {
+			t.Fatalf("record %d: expected %v, got %v", i, expected, decoded[i])
+		}
+	}
+}
@@ -0,0 +1,163 @@
+/* Copyright (c) 2013 Jason Ish
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ * 1. Redistributions of source code must retain the above copyright
+ *    notice, this list of conditions and the following disclaimer.
+ * 2. Redistributions in binary form must reproduce the above copyright
+ *    notice, this list of conditions and the following disclaimer in the
+ *    documentation and/or other materials provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED ``AS IS'' AND ANY EXPRESS OR IMPLIED
+ * WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING
+ * IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+ * POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package unified2
+
+import (
+	"errors"
+	"io"
+	"sort"
+)
+
+// The maximum number of records sampled by DetectVersion.
+const versionSampleRecords = 1000
+
+// VersionInfo describes the record layouts found in a unified2 file,
+// as detected by DetectVersion.  Producers and versions differ in the
+// records they log, so a VersionInfo lets a tool adapt to the input.
+type VersionInfo struct {
+	// The number of records sampled.
+	Records int
+
+	// The event record types found, in ascending order.
+	EventTypes []uint32
+
+	// The extra data types found, such as EXTRA_DATA_TYPE_JSNORM_DATA,
+	// in ascending order.
+	ExtraDataTypes []uint32
+
+	// HasVlan and HasMpls are true if events with a non-zero VlanId
+	// or MplsLabel respectively were found.  Both fields are carried
+	// by the version 2 and application id event layouts, and are zero
+	// if the traffic was not tagged.
+	HasVlan bool
+	HasMpls bool
+
+	// HasInnerVlan is true if events carrying an inner VLAN id were
+	// found, see EventRecord.InnerVlanId.
+	HasInnerVlan bool
+
+	// HasAppId is true if events carrying an application id were
+	// found.
+	HasAppId bool
+
+	// HasIPv6 is true if IPv6 events were found.
+	HasIPv6 bool
+
+	// HasPackets is true if packet records were found.
+	HasPackets bool
+
+	// HasTrailer is true if any record had data following its known
+	// layout, see the Trailer field of the record types.
+	HasTrailer bool
+}
+
+// DetectVersion samples the records of file, starting at the current
+// offset, and reports the record types and layouts found.  Up to 1000
+// records are sampled.  The file offset is reset back to where it was
+// upon entering this function.
+//
+// A partial record at the end of the file ends the sample.
+func DetectVersion(file io.ReadSeeker) (VersionInfo, error) {
+	var info VersionInfo
+
+	offset, err := file.Seek(0, 1)
+	if err != nil {
+		return info, err
+	}
+	defer file.Seek(offset, 0)
+
+	eventTypes := make(map[uint32]bool)
+	extraDataTypes := make(map[uint32]bool)
+
+	for info.Records < versionSampleRecords {
+		raw, err := ReadRawRecord(file)
+		if err != nil {
+			e := &ErrBufferTooSmall{}
+			if errors.As(err, &e) {
+				break
+			}
+			return info, err
+		}
+		container, err := DecodeRawRecord(raw)
+		if err != nil {
+			return info, err
+		}
+		info.Records++
+
+		switch record := container.Record.(type) {
+		case *EventRecord:
+			eventTypes[raw.Type] = true
+			if record.VlanId != 0 {
+				info.HasVlan = true
+			}
+			if record.MplsLabel != 0 {
+				info.HasMpls = true
+			}
+			switch raw.Type {
+			case UNIFIED2_EVENT_APPID, UNIFIED2_EVENT_APPID_IP6:
+				info.HasAppId = true
+			}
+			switch raw.Type {
+			case UNIFIED2_EVENT_IP6, UNIFIED2_EVENT_V2_IP6,
+				UNIFIED2_EVENT_APPID_IP6:
+				info.HasIPv6 = true
+			}
+			if record.HasInnerVlan() {
+				info.HasInnerVlan = true
+			}
+			if len(record.Trailer) > 0 {
+				info.HasTrailer = true
+			}
+		case *PacketRecord:
+			info.HasPackets = true
+			if len(record.Trailer) > 0 {
+				info.HasTrailer = true
+			}
+		case *ExtraDataRecord:
+			extraDataTypes[record.Type] = true
+			if len(record.Trailer) > 0 {
+				info.HasTrailer = true
+			}
+		}
+	}
+
+	info.EventTypes = sortedTypes(eventTypes)
+	info.ExtraDataTypes = sortedTypes(extraDataTypes)
+
+	return info, nil
+}
+
+func sortedTypes(types map[uint32]bool) []uint32 {
+	sorted := make([]uint32, 0, len(types))
+	for t := range types {
+		sorted = append(sorted, t)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
@@ -0,0 +1,56 @@
+package unified2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDetectVersion(t *testing.T) {
+	input, err := os.Open("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+
+	info, err := DetectVersion(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := VersionInfo{
+		Records:        17,
+		EventTypes:     []uint32{UNIFIED2_EVENT_V2},
+		ExtraDataTypes: []uint32{EXTRA_DATA_TYPE_JSNORM_DATA},
+		HasPackets:     true,
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, info)
+	}
+
+	offset, _ := input.Seek(0, 1)
+	if offset != 0 {
+		t.Fatalf("expected file offset to be at 0, was at %d", offset)
+	}
+}
+
+// The VLAN and MPLS flags report tagged events, not just the layout.
+func TestDetectVersionVlan(t *testing.T) {
+	buf, err := ioutil.ReadFile("test/multi-record-event.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tag the first event, a V2 event, with VLAN 100.
+	binary.BigEndian.PutUint16(buf[rawHeaderLen+56:], 100)
+	info, err := DetectVersion(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.HasVlan || info.HasMpls {
+		t.Fatalf("expected VLAN and no MPLS, got %+v", info)
+	}
+}